@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// These are the commands an agent may accept.
+const (
+	Emit          = "emit"        // send a profile now
+	Reset         = "reset"       // reset the profile counters
+	SetSampleRate = "sample-rate" // change the sampling rate; args are SampleRate
+	StackDump     = "stack-dump"  // reply with the stacks of all threads
+	Flush         = "flush"       // send any buffered messages
+)
+
+// SampleRate is the argument of SetSampleRate.
+type SampleRate struct {
+	Hz int `json:"hz"`
+}
+
+// Reply is an agent's answer to a command.
+type Reply struct {
+	ID     uint64          `json:"id"`
+	Error  string          `json:"error,omitempty"` // empty on success
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+var (
+	// ErrUnsupported is returned for commands that the agent did not
+	// agree to in the handshake.
+	ErrUnsupported = errors.New("command not supported by agent")
+
+	errDisconnected = errors.New("agent disconnected before replying")
+)
+
+// command is a command as written in version 1 of the protocol: a line of
+// JSON. Agents answer with a message of type "reply", whose data is a Reply
+// with the same ID. An ID of zero asks for no reply.
+type command struct {
+	Version int         `json:"v"`
+	ID      uint64      `json:"id"`
+	Command string      `json:"command"`
+	Args    interface{} `json:"args,omitempty"`
+}
+
+// Commander sends commands to an agent, using the protocol agreed in a
+// Handshake, and matches the agent's replies to them.
+type Commander struct {
+	w io.Writer
+	h Handshake
+
+	// Writes to w may block for as long as the agent does not read, so
+	// they are serialized by wmu alone, and never made while holding mu.
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	closed  bool   // the agent disconnected
+	next    uint64 // ID of the last command sent
+	pending map[uint64]chan Reply
+}
+
+// NewCommander returns a Commander that writes commands to w.
+func NewCommander(w io.Writer, h Handshake) *Commander {
+	return &Commander{
+		w:       w,
+		h:       h,
+		pending: make(map[uint64]chan Reply),
+	}
+}
+
+// Send sends the named command, with args if they are not nil. The returned
+// channel receives the agent's reply, and closes without one if the agent
+// disconnects first. Agents that use command protocol 0 cannot reply, so
+// nothing can be sent to them this way; use Emit instead.
+func (c *Commander) Send(name string, args interface{}) (<-chan Reply, error) {
+	if !c.h.Uses(name) || c.h.CommandProtocol == 0 {
+		return nil, ErrUnsupported
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, errDisconnected
+	}
+	c.next++
+	id := c.next
+	reply := make(chan Reply, 1)
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	if err := c.write(command{Version: 1, ID: id, Command: name, Args: args}); err != nil {
+		c.forget(reply)
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Do sends the named command and waits up to timeout for its reply. It
+// returns the reply's result, or its error. The timeout includes the time
+// taken to send the command, which may block if the agent stops reading.
+func (c *Commander) Do(name string, args interface{}, timeout time.Duration) (json.RawMessage, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	type sent struct {
+		reply <-chan Reply
+		err   error
+	}
+	done := make(chan sent, 1)
+	go func() {
+		reply, err := c.Send(name, args)
+		done <- sent{reply, err}
+	}()
+
+	var reply <-chan Reply
+	select {
+	case s := <-done:
+		if s.err != nil {
+			return nil, s.err
+		}
+		reply = s.reply
+	case <-t.C:
+		go func() {
+			if s := <-done; s.err == nil {
+				c.forget(s.reply)
+			}
+		}()
+		return nil, fmt.Errorf("agent: %v: could not send within %v", name, timeout)
+	}
+	select {
+	case r, ok := <-reply:
+		if !ok {
+			return nil, errDisconnected
+		}
+		if r.Error != "" {
+			return nil, fmt.Errorf("agent: %v: %v", name, r.Error)
+		}
+		return r.Result, nil
+	case <-t.C:
+		c.forget(reply)
+		return nil, fmt.Errorf("agent: %v: no reply within %v", name, timeout)
+	}
+}
+
+// Emit asks the agent to send a profile. It does not wait for a reply.
+func (c *Commander) Emit() error {
+	if !c.h.Uses(Emit) {
+		return ErrUnsupported
+	}
+	if c.h.CommandProtocol == 0 {
+		c.wmu.Lock()
+		defer c.wmu.Unlock()
+		_, err := c.w.Write([]byte{0})
+		return err
+	}
+	return c.write(command{Version: 1, Command: Emit})
+}
+
+// write writes cmd to c's connection.
+func (c *Commander) write(cmd command) error {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err = c.w.Write(append(b, '\n'))
+	return err
+}
+
+// forget stops waiting for reply.
+func (c *Commander) forget(reply <-chan Reply) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, r := range c.pending {
+		if r == reply {
+			delete(c.pending, id)
+		}
+	}
+}
+
+// Serve returns a MessageSource that passes on the messages of in, except
+// for replies to commands, which go to the senders waiting for them. When
+// in closes, senders still waiting get no reply.
+func (c *Commander) Serve(in MessageSource) MessageSource {
+	s := replyFilter{in: in, out: make(chan Message), c: c}
+	go s.serve()
+	return s
+}
+
+// replyFilter takes the replies out of a stream of messages.
+type replyFilter struct {
+	in  MessageSource
+	out chan Message
+	c   *Commander
+}
+
+// Output returns the messages of f's input that are not replies.
+func (f replyFilter) Output() <-chan Message { return f.out }
+
+func (f replyFilter) serve() {
+	defer close(f.out)
+	defer f.c.disconnect()
+	for msg := range f.in.Output() {
+		if msg.Type != "reply" {
+			f.out <- msg
+			continue
+		}
+		var r Reply
+		if err := json.Unmarshal(msg.Data, &r); err != nil {
+			log.Printf("agent: malformed reply %s: %v", msg.Data, err)
+			continue
+		}
+		f.c.deliver(r)
+	}
+}
+
+// deliver hands r to the sender of the command it answers.
+func (c *Commander) deliver(r Reply) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reply, ok := c.pending[r.ID]
+	if !ok {
+		log.Printf("agent: reply to unknown command %v", r.ID)
+		return
+	}
+	delete(c.pending, r.ID)
+	reply <- r
+}
+
+// disconnect closes the channels of the commands still waiting for replies.
+func (c *Commander) disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for id, reply := range c.pending {
+		close(reply)
+		delete(c.pending, id)
+	}
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCommander(t *testing.T) {
+	r, w := io.Pipe()
+	h := Handshake{Commands: []string{Emit, SetSampleRate, StackDump}, CommandProtocol: 1}
+	c := NewCommander(w, h)
+	in := make(source)
+	out := c.Serve(in)
+
+	// The agent answers each command with its own ID.
+	go func() {
+		lines := bufio.NewScanner(r)
+		for lines.Scan() {
+			var cmd command
+			if err := json.Unmarshal(lines.Bytes(), &cmd); err != nil || cmd.ID == 0 {
+				continue
+			}
+			if cmd.Command == SetSampleRate && cmd.Args == nil {
+				continue // left unanswered
+			}
+			data := fmt.Sprintf(`{"id":%v,"result":%q}`, cmd.ID, cmd.Command)
+			if cmd.Command == StackDump {
+				data = fmt.Sprintf(`{"id":%v,"error":"not now"}`, cmd.ID)
+			}
+			in <- Message{Type: "reply", Data: json.RawMessage(data)}
+		}
+	}()
+	// Other messages are passed on.
+	go func() { in <- Message{Type: "profile"} }()
+	if msg := <-out.Output(); msg.Type != "profile" {
+		t.Errorf("expected profile, got %v", msg.Type)
+	}
+
+	res, err := c.Do(SetSampleRate, SampleRate{Hz: 100}, time.Second)
+	if err != nil || string(res) != `"sample-rate"` {
+		t.Errorf("expected sample-rate result, got %s: %v", res, err)
+	}
+	if _, err := c.Do(StackDump, nil, time.Second); err == nil {
+		t.Error("expected error reply")
+	}
+	if _, err := c.Do(Flush, nil, time.Second); err != ErrUnsupported {
+		t.Errorf("expected %v, got %v", ErrUnsupported, err)
+	}
+	if err := c.Emit(); err != nil {
+		t.Error(err)
+	}
+
+	// Commands still waiting get no reply once the agent disconnects.
+	reply, err := c.Send(SetSampleRate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(in)
+	defer r.Close()
+	if _, ok := <-reply; ok {
+		t.Error("expected no reply")
+	}
+	if _, open := <-out.Output(); open {
+		t.Error("expected output to close")
+	}
+	if _, err := c.Send(SetSampleRate, nil); err == nil {
+		t.Error("expected error sending to disconnected agent")
+	}
+}
+
+func TestCommanderTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewCommander(&buf, Handshake{Commands: []string{Flush}, CommandProtocol: 1})
+	if _, err := c.Do(Flush, nil, 10*time.Millisecond); err == nil {
+		t.Error("expected timeout")
+	}
+	if len(c.pending) != 0 {
+		t.Errorf("expected no pending commands, got %v", len(c.pending))
+	}
+	if buf.String() != `{"v":1,"id":1,"command":"flush"}`+"\n" {
+		t.Errorf("unexpected command %q", buf.String())
+	}
+}
+
+func TestEmit(t *testing.T) {
+	cases := []struct {
+		h      Handshake
+		expect string
+	}{
+		{h: legacy, expect: "\x00"},
+		{h: Handshake{Commands: []string{Emit}, CommandProtocol: 1}, expect: `{"v":1,"id":0,"command":"emit"}` + "\n"},
+	}
+	for i, c := range cases {
+		var buf bytes.Buffer
+		if err := NewCommander(&buf, c.h).Emit(); err != nil {
+			t.Errorf("case %v: %v", i, err)
+		}
+		if buf.String() != c.expect {
+			t.Errorf("case %v: expected %q, got %q", i, c.expect, buf.String())
+		}
+	}
+}
+
+func TestSendProtocol0(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewCommander(&buf, legacy)
+	if _, err := c.Send(Emit, nil); err != ErrUnsupported {
+		t.Errorf("expected %v, got %v", ErrUnsupported, err)
+	}
+	if _, err := c.Do(Emit, nil, time.Second); err != ErrUnsupported {
+		t.Errorf("expected %v, got %v", ErrUnsupported, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing written, got %q", buf.String())
+	}
+}
+
+// blockedWriter is an agent connection whose agent has stopped reading.
+type blockedWriter struct{ unblock chan struct{} }
+
+func (w blockedWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return 0, errors.New("agent disconnected")
+}
+
+func TestCommanderBlocked(t *testing.T) {
+	w := blockedWriter{make(chan struct{})}
+	defer close(w.unblock)
+	c := NewCommander(w, Handshake{Commands: []string{StackDump}, CommandProtocol: 1})
+
+	start := time.Now()
+	if _, err := c.Do(StackDump, nil, 50*time.Millisecond); err == nil {
+		t.Error("expected timeout")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected Do to time out, took %v", d)
+	}
+
+	// Replies and disconnection are not held up by the blocked write.
+	done := make(chan struct{})
+	go func() {
+		c.deliver(Reply{ID: 1})
+		c.disconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected deliver and disconnect not to wait for the write")
+	}
+}
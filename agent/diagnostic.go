@@ -0,0 +1,210 @@
+package agent
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is the data of a "diagnostic" message: a summary of a report
+// written to standard error by a sanitizer, a failed assert, or the C library
+// on detecting heap corruption.
+type Diagnostic struct {
+	// Tool is what wrote the report: the name of a sanitizer, such as
+	// "AddressSanitizer", or "assert" or "glibc".
+	Tool      string   `json:"tool"`
+	Kind      string   `json:"kind"`    // such as "heap-use-after-free" or "double free"
+	Summary   string   `json:"summary"` // the line that describes the error
+	Location  string   `json:"location,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Frames    []Frame  `json:"frames,omitempty"` // of the stack where the error occurred
+}
+
+// Frame is a stack frame in a Diagnostic.
+type Frame struct {
+	PC       string `json:"pc,omitempty"`
+	Function string `json:"function,omitempty"`
+	Location string `json:"location,omitempty"` // source file, or module and offset
+}
+
+// These limit the size of a Diagnostic.
+const (
+	maxFrames     = 64
+	maxAddresses  = 8
+	maxReportSize = 1000 // lines; a report longer than this is cut short
+)
+
+var (
+	// ==1234==ERROR: AddressSanitizer: heap-use-after-free on address 0x...
+	sanitizerError = regexp.MustCompile(`^==\d+==ERROR: (\w+Sanitizer): (.*)$`)
+	// SUMMARY: AddressSanitizer: heap-use-after-free a.c:6:10 in main
+	sanitizerSummary = regexp.MustCompile(`^SUMMARY: (\w+Sanitizer): (.*)$`)
+	// a.c:3:5: runtime error: signed integer overflow: ...
+	ubsanError = regexp.MustCompile(`^(\S+:\d+(?::\d+)?): runtime error: (.*)$`)
+	//     #0 0x4f4d3b in main /tmp/a.c:6:10
+	//     #1 0x7f2c41b2e82f  (/lib/x86_64-linux-gnu/libc.so.6+0x2082f)
+	sanitizerFrame = regexp.MustCompile(`^\s*#\d+ (0x[0-9a-f]+)(?: in (\S+))?\s*(.*)$`)
+	// prog: a.c:5: main: Assertion `x == 1' failed.
+	assertion = regexp.MustCompile("^.*?: (\\S+:\\d+): (.*?): Assertion `(.*)' failed\\.$")
+	// free(): double free detected in tcache 2
+	// *** Error in `./prog': double free or corruption (fasttop): 0x... ***
+	glibcError = regexp.MustCompile(`^(?:\*\*\* Error in ` + "`" + `.*': )?((?:(?:free|malloc|realloc|munmap_chunk|malloc_consolidate)\(\): |double free or corruption|corrupted (?:size vs\. prev_size|double-linked list)).*?)(?: \*\*\*)?$`)
+	// *** stack smashing detected ***: terminated
+	stackSmashing = regexp.MustCompile(`^\*\*\* stack smashing detected \*\*\*`)
+
+	hexAddress = regexp.MustCompile(`0x[0-9a-fA-F]+`)
+)
+
+// diagnoser recognizes Diagnostics in the lines of an app's standard error. A
+// nil *diagnoser recognizes none.
+type diagnoser struct {
+	cur    *Diagnostic
+	report bool // cur is a multi-line sanitizer report, ended by its summary
+	lines  int  // of cur
+	stack  int  // 0 before the first stack of cur, 1 within it, 2 after it
+}
+
+// line reads a line and returns any Diagnostics it completes.
+func (d *diagnoser) line(s string) []Diagnostic {
+	if d == nil {
+		return nil
+	}
+	var done []Diagnostic
+	if d.cur != nil {
+		d.lines++
+		if m := sanitizerFrame.FindStringSubmatch(s); m != nil {
+			d.frame(m)
+			return nil
+		}
+		if d.stack == 1 {
+			d.stack = 2
+		}
+		// A leak report may be summarized by AddressSanitizer, which
+		// runs LeakSanitizer.
+		if m := sanitizerSummary.FindStringSubmatch(s); m != nil {
+			d.summary(m[2])
+			return d.finish()
+		}
+		if d.report && d.lines < maxReportSize {
+			return nil
+		}
+		// The line is not part of the diagnostic; it may start another.
+		done = d.finish()
+	}
+
+	switch {
+	case sanitizerError.MatchString(s):
+		m := sanitizerError.FindStringSubmatch(s)
+		var kind string
+		if f := strings.Fields(m[2]); len(f) > 0 {
+			kind = f[0]
+		}
+		if strings.HasPrefix(m[2], "detected memory leaks") {
+			kind = "memory-leak"
+		}
+		d.start(Diagnostic{Tool: m[1], Kind: kind, Summary: m[2], Addresses: addresses(m[2])}, true)
+	case ubsanError.MatchString(s):
+		m := ubsanError.FindStringSubmatch(s)
+		kind := strings.SplitN(m[2], ":", 2)[0]
+		d.start(Diagnostic{
+			Tool:      "UndefinedBehaviorSanitizer",
+			Kind:      kind,
+			Summary:   m[2],
+			Location:  m[1],
+			Addresses: addresses(m[2]),
+		}, false)
+	case assertion.MatchString(s):
+		m := assertion.FindStringSubmatch(s)
+		done = append(done, Diagnostic{
+			Tool:     "assert",
+			Kind:     "assertion failure",
+			Summary:  "Assertion `" + m[3] + "' failed.",
+			Location: m[1],
+			Frames:   []Frame{{Function: m[2], Location: m[1]}},
+		})
+	case stackSmashing.MatchString(s):
+		done = append(done, Diagnostic{Tool: "glibc", Kind: "stack smashing", Summary: s})
+	case glibcError.MatchString(s):
+		m := glibcError.FindStringSubmatch(s)
+		kind := "heap corruption"
+		if strings.Contains(m[1], "double free") {
+			kind = "double free"
+		}
+		done = append(done, Diagnostic{Tool: "glibc", Kind: kind, Summary: m[1], Addresses: addresses(s)})
+	}
+	return done
+}
+
+// end returns the Diagnostic being read, if any, at the end of the stream.
+func (d *diagnoser) end() []Diagnostic {
+	if d == nil || d.cur == nil {
+		return nil
+	}
+	return d.finish()
+}
+
+func (d *diagnoser) start(diag Diagnostic, report bool) {
+	d.cur = &diag
+	d.report = report
+	d.lines = 0
+	d.stack = 0
+}
+
+func (d *diagnoser) finish() []Diagnostic {
+	diag := *d.cur
+	d.cur = nil
+	return []Diagnostic{diag}
+}
+
+// frame adds the frame matched by m, if it belongs to the first stack of the
+// report. Later stacks, such as where memory was freed, are left out.
+func (d *diagnoser) frame(m []string) {
+	if d.stack == 2 || len(d.cur.Frames) >= maxFrames {
+		return
+	}
+	d.stack = 1
+	loc := strings.TrimSpace(m[3])
+	loc = strings.TrimSuffix(strings.TrimPrefix(loc, "("), ")")
+	d.cur.Frames = append(d.cur.Frames, Frame{PC: m[1], Function: m[2], Location: loc})
+}
+
+// summary completes the current Diagnostic with the text of its summary line,
+// which may give the location of the error.
+func (d *diagnoser) summary(s string) {
+	if d.cur.Location != "" {
+		return
+	}
+	// The kind is followed by the location, if known.
+	f := strings.Fields(s)
+	if len(f) > 1 && isLocation(f[1]) {
+		d.cur.Location = f[1]
+	}
+}
+
+// isLocation reports whether s looks like file:line, optionally followed by
+// :column.
+func isLocation(s string) bool {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return false
+	}
+	for _, p := range parts[1:] {
+		if _, err := strconv.Atoi(p); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// addresses returns the distinct hexadecimal addresses in s.
+func addresses(s string) []string {
+	var list []string
+	seen := make(map[string]bool)
+	for _, a := range hexAddress.FindAllString(s, -1) {
+		if !seen[a] && len(list) < maxAddresses {
+			seen[a] = true
+			list = append(list, a)
+		}
+	}
+	return list
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const asanReport = `=================================================================
+==4242==ERROR: AddressSanitizer: heap-use-after-free on address 0x602000000010 at pc 0x0000004f4d3c bp 0x7ffd6bb0 sp 0x7ffd6ba8
+READ of size 4 at 0x602000000010 thread T0
+    #0 0x4f4d3b in main /src/a.c:6:10
+    #1 0x7f2c41b2e82f  (/lib/x86_64-linux-gnu/libc.so.6+0x2082f)
+
+0x602000000010 is located 0 bytes inside of 4-byte region [0x602000000010,0x602000000014)
+freed by thread T0 here:
+    #0 0x4c4d2f in free
+    #1 0x4f4d0a in main /src/a.c:5:3
+
+SUMMARY: AddressSanitizer: heap-use-after-free /src/a.c:6:10 in main
+==4242==ABORTING`
+
+func TestDiagnoser(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect []string
+	}{
+		{input: "nothing to see\nhere", expect: nil},
+		{
+			input: asanReport,
+			expect: []string{`{"tool":"AddressSanitizer","kind":"heap-use-after-free",` +
+				`"summary":"heap-use-after-free on address 0x602000000010 at pc 0x0000004f4d3c bp 0x7ffd6bb0 sp 0x7ffd6ba8",` +
+				`"location":"/src/a.c:6:10","addresses":["0x602000000010","0x0000004f4d3c","0x7ffd6bb0","0x7ffd6ba8"],` +
+				`"frames":[{"pc":"0x4f4d3b","function":"main","location":"/src/a.c:6:10"},` +
+				`{"pc":"0x7f2c41b2e82f","location":"/lib/x86_64-linux-gnu/libc.so.6+0x2082f"}]}`},
+		},
+		{
+			input: "a.c:3:5: runtime error: signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'\nnext",
+			expect: []string{`{"tool":"UndefinedBehaviorSanitizer","kind":"signed integer overflow",` +
+				`"summary":"signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'","location":"a.c:3:5"}`},
+		},
+		{
+			input: "==7==ERROR: LeakSanitizer: detected memory leaks\n\nDirect leak of 4 byte(s) in 1 object(s) allocated from:\n    #0 0x4c4e8f in malloc\n\nSUMMARY: AddressSanitizer: 4 byte(s) leaked in 1 allocation(s).",
+			expect: []string{`{"tool":"LeakSanitizer","kind":"memory-leak","summary":"detected memory leaks",` +
+				`"frames":[{"pc":"0x4c4e8f","function":"malloc"}]}`},
+		},
+		{
+			input: "prog: a.c:5: main: Assertion `x == 1' failed.",
+			expect: []string{"{\"tool\":\"assert\",\"kind\":\"assertion failure\",\"summary\":\"Assertion `x == 1' failed.\"," +
+				`"location":"a.c:5","frames":[{"function":"main","location":"a.c:5"}]}`},
+		},
+		{
+			input:  "free(): double free detected in tcache 2",
+			expect: []string{`{"tool":"glibc","kind":"double free","summary":"free(): double free detected in tcache 2"}`},
+		},
+		{
+			input: "*** Error in `./prog': malloc(): memory corruption: 0x0000000001c2d010 ***\nfree memory: 10 MB",
+			expect: []string{`{"tool":"glibc","kind":"heap corruption","summary":"malloc(): memory corruption: 0x0000000001c2d010",` +
+				`"addresses":["0x0000000001c2d010"]}`},
+		},
+		{
+			// A report cut short by the end of the stream.
+			input:  "==1==ERROR: AddressSanitizer: SEGV on unknown address 0x000000000000",
+			expect: []string{`{"tool":"AddressSanitizer","kind":"SEGV","summary":"SEGV on unknown address 0x000000000000","addresses":["0x000000000000"]}`},
+		},
+	}
+	for i, c := range cases {
+		var d diagnoser
+		var got []string
+		for _, line := range strings.Split(c.input, "\n") {
+			for _, diag := range d.line(line) {
+				b, _ := json.Marshal(diag)
+				got = append(got, string(b))
+			}
+		}
+		for _, diag := range d.end() {
+			b, _ := json.Marshal(diag)
+			got = append(got, string(b))
+		}
+		if strings.Join(got, "\n") != strings.Join(c.expect, "\n") {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// frameReader splits a stream of JSON objects into records, by tracking the
+// nesting of braces outside of strings. It never holds more than max bytes of
+// a record, so that an agent cannot make the client allocate without limit.
+type frameReader struct {
+	r   *bufio.Reader
+	max int
+}
+
+func newFrameReader(r io.Reader, max int) *frameReader {
+	return &frameReader{r: bufio.NewReader(r), max: max}
+}
+
+// tooLarge reports a record that was skipped because it exceeded the maximum
+// size.
+type tooLarge struct {
+	size, max int64
+}
+
+func (e tooLarge) Error() string {
+	return fmt.Sprintf("message of %v bytes exceeds maximum of %v", e.size, e.max)
+}
+
+// garbage reports bytes found between records, which were skipped.
+type garbage struct {
+	n    int
+	head []byte // the first few bytes
+}
+
+func (e garbage) Error() string {
+	return fmt.Sprintf("skipped %v bytes between messages: %q", e.n, e.head)
+}
+
+// maxQuote is the number of bytes of a bad record quoted in errors.
+const maxQuote = 256
+
+// next returns the next record. A record larger than the maximum size is
+// skipped, as are bytes that are not part of a record, and next reports them
+// with a tooLarge or garbage error. At the end of the stream, next returns
+// io.EOF, or io.ErrUnexpectedEOF with the incomplete record.
+func (f *frameReader) next() ([]byte, error) {
+	if err := f.skip(); err != nil {
+		return nil, err
+	}
+
+	var (
+		rec      []byte
+		size     int
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	for {
+		c, err := f.r.ReadByte()
+		if err == io.EOF {
+			return rec, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		size++
+		if size <= f.max {
+			rec = append(rec, c)
+		}
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if size > f.max {
+		return nil, tooLarge{size: int64(size), max: int64(f.max)}
+	}
+	return rec, nil
+}
+
+// skip advances to the start of the next record. It returns a garbage error
+// if it skipped anything other than whitespace.
+func (f *frameReader) skip() error {
+	var g garbage
+	for {
+		c, err := f.r.ReadByte()
+		if err != nil {
+			if g.n > 0 {
+				return g
+			}
+			return err
+		}
+		switch {
+		case c == '{':
+			f.r.UnreadByte()
+			if g.n > 0 {
+				return g
+			}
+			return nil
+		case isSpace(c):
+		default:
+			g.n++
+			if len(g.head) < maxQuote {
+				g.head = append(g.head, c)
+			}
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// lengthFrameReader splits a stream into records, each preceded by its length
+// as a 4-byte big-endian integer. It is used for binary encodings.
+type lengthFrameReader struct {
+	r       *bufio.Reader
+	max     int
+	started bool
+}
+
+func newLengthFrameReader(r io.Reader, max int) *lengthFrameReader {
+	return &lengthFrameReader{r: bufio.NewReader(r), max: max}
+}
+
+// next returns the next record, or skips it and returns a tooLarge error if
+// it is larger than the maximum size. At the end of the stream, next returns
+// io.EOF, or io.ErrUnexpectedEOF with the incomplete record.
+func (f *lengthFrameReader) next() ([]byte, error) {
+	if !f.started {
+		// Whitespace may follow the agent's hello. It cannot be the
+		// start of a length within the maximum size, which would be
+		// at least 144 MiB.
+		f.started = true
+		for {
+			c, err := f.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if !isSpace(c) {
+				f.r.UnreadByte()
+				break
+			}
+		}
+	}
+
+	var head [4]byte
+	if _, err := io.ReadFull(f.r, head[:]); err != nil {
+		return nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(head[:]))
+	if size > int64(f.max) {
+		if _, err := io.CopyN(ioutil.Discard, f.r, size); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, tooLarge{size: size, max: int64(f.max)}
+	}
+	rec := make([]byte, size)
+	if n, err := io.ReadFull(f.r, rec); err != nil {
+		return rec[:n], io.ErrUnexpectedEOF
+	}
+	return rec, nil
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrameReader(t *testing.T) {
+	input := `{"a":"}{\"}"} {"b":{"c":1}}` + "\n" +
+		`junk{"d":2}{"too":"large, this one"}{"e":3}{"f"`
+	type result struct {
+		rec string
+		err string
+	}
+	expect := []result{
+		{rec: `{"a":"}{\"}"}`},
+		{rec: `{"b":{"c":1}}`},
+		{err: `skipped 4 bytes between messages: "junk"`},
+		{rec: `{"d":2}`},
+		{err: "message of 25 bytes exceeds maximum of 20"},
+		{rec: `{"e":3}`},
+		{rec: `{"f"`, err: io.ErrUnexpectedEOF.Error()},
+		{err: io.EOF.Error()},
+	}
+	f := newFrameReader(strings.NewReader(input), 20)
+	for i, e := range expect {
+		rec, err := f.next()
+		got := result{rec: string(rec)}
+		if err != nil {
+			got.err = err.Error()
+		}
+		if got != e {
+			t.Errorf("case %v: expected %+v, got %+v", i, e, got)
+		}
+	}
+}
+
+func TestLengthFrameReader(t *testing.T) {
+	frame := func(s string) string {
+		n := len(s)
+		return string([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}) + s
+	}
+	input := "\n" + frame("abc") + frame(strings.Repeat("x", 30)) + frame("") + frame("defg")[:6]
+	type result struct {
+		rec string
+		err string
+	}
+	expect := []result{
+		{rec: "abc"},
+		{err: "message of 30 bytes exceeds maximum of 20"},
+		{rec: ""},
+		{rec: "de", err: io.ErrUnexpectedEOF.Error()},
+		{err: io.EOF.Error()},
+	}
+	f := newLengthFrameReader(strings.NewReader(input), 20)
+	for i, e := range expect {
+		rec, err := f.next()
+		got := result{rec: string(rec)}
+		if err != nil {
+			got.err = err.Error()
+		}
+		if got != e {
+			t.Errorf("case %v: expected %+v, got %+v", i, e, got)
+		}
+	}
+}
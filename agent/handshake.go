@@ -0,0 +1,253 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aukletio/Auklet-Client-C/version"
+)
+
+// Version is a semantic version, as described at https://semver.org.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string // pre-release identifiers, if any
+}
+
+// ParseVersion parses a semantic version such as "1.4.2" or "v2.0.0-rc.1".
+// Build metadata, following a "+", is ignored. A missing minor or patch
+// number is taken to be zero.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	rest := strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		rest, v.Pre = rest[:i], rest[i+1:]
+		if v.Pre == "" {
+			return Version{}, fmt.Errorf("invalid version %q: empty pre-release", s)
+		}
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q: too many numbers", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || strings.HasPrefix(p, "+") {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// Less reports whether v precedes w. Pre-release identifiers are compared as
+// strings, and a pre-release precedes the release of the same version.
+func (v Version) Less(w Version) bool {
+	switch {
+	case v.Major != w.Major:
+		return v.Major < w.Major
+	case v.Minor != w.Minor:
+		return v.Minor < w.Minor
+	case v.Patch != w.Patch:
+		return v.Patch < w.Patch
+	case v.Pre == w.Pre:
+		return false
+	case v.Pre == "":
+		return false
+	case w.Pre == "":
+		return true
+	default:
+		return v.Pre < w.Pre
+	}
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%v.%v.%v", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Capabilities describe what an agent or the client can do.
+type Capabilities struct {
+	Messages  []string `json:"messages"`  // types of message sent by the agent
+	Encodings []string `json:"encodings"` // encodings of those messages
+	Commands  []string `json:"commands"`  // commands accepted by the agent
+
+	// CommandProtocol is the version of the command protocol understood
+	// by the agent. Version 0 is a single 0 byte for Emit; version 1 is
+	// described by Commander.
+	CommandProtocol int `json:"commandProtocol,omitempty"`
+}
+
+// Hello is the first message an agent sends. Agents that advertise their
+// capabilities expect a reply from the client.
+type Hello struct {
+	Version      string        `json:"version"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+}
+
+// supported is what the client can use.
+var supported = Capabilities{
+	Messages:        []string{"profile", "event", "log"},
+	Encodings:       []string{"json", "msgpack"},
+	Commands:        []string{Emit, Reset, SetSampleRate, StackDump, Flush},
+	CommandProtocol: 1,
+}
+
+// compatibility lists the ranges of agent versions that the client works
+// with, and the capabilities assumed of agents in each range that do not
+// advertise theirs.
+var compatibility = []struct {
+	min, max Version // min is included, max is not
+	legacy   Capabilities
+}{
+	{
+		min: Version{Major: 0},
+		max: Version{Major: 2},
+		legacy: Capabilities{
+			Messages:  []string{"profile", "event", "log"},
+			Encodings: []string{"json"},
+			Commands:  []string{Emit},
+		},
+	},
+}
+
+// IncompatibleError reports that the client cannot work with an agent.
+type IncompatibleError struct {
+	Version string // as given by the agent
+	Reason  string
+}
+
+func (e IncompatibleError) Error() string {
+	return fmt.Sprintf("agent version %q is not supported by client version %v: %v", e.Version, version.Version, e.Reason)
+}
+
+// Handshake holds what the client and an agent agreed to use.
+type Handshake struct {
+	Version  Version
+	Encoding string
+	Messages []string
+	Commands []string
+
+	CommandProtocol int
+
+	// Extended is true if the agent advertised its capabilities, and thus
+	// expects a reply.
+	Extended bool
+}
+
+// Negotiate checks the agent version in h against the compatibility table,
+// and returns what the client and the agent will use. If the two cannot work
+// together, it returns an IncompatibleError. Agents whose versions cannot be
+// parsed predate versioning, and are assumed to have the capabilities of the
+// first range of the table.
+func Negotiate(h Hello) (Handshake, error) {
+	var caps *Capabilities
+	v, err := ParseVersion(h.Version)
+	if err != nil {
+		log.Printf("handshake: %v; assuming a legacy agent", err)
+		legacy := compatibility[0].legacy
+		caps = &legacy
+	}
+	for _, c := range compatibility {
+		if caps != nil {
+			break
+		}
+		if !v.Less(c.min) && v.Less(c.max) {
+			legacy := c.legacy
+			caps = &legacy
+		}
+	}
+	if caps == nil {
+		return Handshake{}, IncompatibleError{Version: h.Version, Reason: "no compatible version range"}
+	}
+	if h.Capabilities != nil {
+		caps = h.Capabilities
+	}
+
+	hs := Handshake{
+		Version:         v,
+		Messages:        intersect(caps.Messages, supported.Messages),
+		Commands:        intersect(caps.Commands, supported.Commands),
+		CommandProtocol: caps.CommandProtocol,
+		Extended:        h.Capabilities != nil,
+	}
+	if hs.CommandProtocol > supported.CommandProtocol {
+		hs.CommandProtocol = supported.CommandProtocol
+	}
+	if hs.CommandProtocol == 0 {
+		// Emit is the only command that can be sent as a byte.
+		hs.Commands = intersect(hs.Commands, []string{Emit})
+	}
+	encodings := intersect(caps.Encodings, supported.Encodings)
+	if len(encodings) == 0 {
+		return Handshake{}, IncompatibleError{
+			Version: h.Version,
+			Reason:  fmt.Sprintf("no common encoding in %v", caps.Encodings),
+		}
+	}
+	// The agent's order of preference wins, since the messages are its
+	// to encode.
+	hs.Encoding = encodings[0]
+	return hs, nil
+}
+
+// intersect returns the elements of a that are also in b, in the order of a.
+func intersect(a, b []string) []string {
+	in := make(map[string]bool)
+	for _, s := range b {
+		in[s] = true
+	}
+	var out []string
+	for _, s := range a {
+		if in[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Uses reports whether the client may send the named command to the agent.
+func (h Handshake) Uses(command string) bool {
+	for _, c := range h.Commands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// Reply tells an agent that advertised its capabilities what the client will
+// use, as a line of JSON. It writes nothing to other agents.
+func (h Handshake) Reply(w io.Writer) error {
+	if !h.Extended {
+		return nil
+	}
+	b, err := json.Marshal(struct {
+		Version         string   `json:"version"` // of the client
+		Encoding        string   `json:"encoding"`
+		Messages        []string `json:"messages"`
+		Commands        []string `json:"commands"`
+		CommandProtocol int      `json:"commandProtocol"`
+	}{
+		Version:         version.Version,
+		Encoding:        h.Encoding,
+		Messages:        h.Messages,
+		Commands:        h.Commands,
+		CommandProtocol: h.CommandProtocol,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
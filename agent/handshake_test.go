@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		in     string
+		expect string
+		ok     bool
+	}{
+		{in: "1.2.3", expect: "1.2.3", ok: true},
+		{in: "v1.2.3", expect: "1.2.3", ok: true},
+		{in: "1.0", expect: "1.0.0", ok: true},
+		{in: "2", expect: "2.0.0", ok: true},
+		{in: "1.2.3-rc.1+build.5", expect: "1.2.3-rc.1", ok: true},
+		{in: "", ok: false},
+		{in: "something", ok: false},
+		{in: "1.2.3.4", ok: false},
+		{in: "1.-2.3", ok: false},
+		{in: "1.2.3-", ok: false},
+	}
+	for i, c := range cases {
+		v, err := ParseVersion(c.in)
+		if (err == nil) != c.ok {
+			t.Errorf("case %v: expected ok %v, got %v", i, c.ok, err)
+			continue
+		}
+		if c.ok && v.String() != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, v)
+		}
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	cases := []struct {
+		v, w   string
+		expect bool
+	}{
+		{v: "1.2.3", w: "1.2.3", expect: false},
+		{v: "1.2.3", w: "1.2.4", expect: true},
+		{v: "1.3.0", w: "1.2.9", expect: false},
+		{v: "0.9.9", w: "1.0.0", expect: true},
+		{v: "1.0.0-rc.1", w: "1.0.0", expect: true},
+		{v: "1.0.0", w: "1.0.0-rc.1", expect: false},
+		{v: "1.0.0-alpha", w: "1.0.0-beta", expect: true},
+	}
+	for i, c := range cases {
+		v, _ := ParseVersion(c.v)
+		w, _ := ParseVersion(c.w)
+		if got := v.Less(w); got != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		hello    Hello
+		ok       bool
+		commands string
+		extended bool
+	}{
+		{
+			hello:    Hello{Version: "1.4.0"},
+			ok:       true,
+			commands: "[emit]",
+		}, {
+			hello: Hello{
+				Version: "1.5.0",
+				Capabilities: &Capabilities{
+					Messages:  []string{"event", "trace"},
+					Encodings: []string{"json"},
+				},
+			},
+			ok:       true,
+			commands: "[]",
+			extended: true,
+		},
+		{
+			hello: Hello{
+				Version: "1.5.0",
+				Capabilities: &Capabilities{
+					Encodings: []string{"json"},
+					Commands:  []string{"emit", "flush"},
+				},
+			},
+			ok:       true,
+			commands: "[emit]",
+			extended: true,
+		}, {
+			hello: Hello{
+				Version: "1.5.0",
+				Capabilities: &Capabilities{
+					Encodings:       []string{"json"},
+					Commands:        []string{"emit", "flush", "launch"},
+					CommandProtocol: 2,
+				},
+			},
+			ok:       true,
+			commands: "[emit flush]",
+			extended: true,
+		},
+		{hello: Hello{Version: "2.0.0"}, ok: false},
+		{
+			// an agent that predates versioning
+			hello:    Hello{Version: "something"},
+			ok:       true,
+			commands: "[emit]",
+		},
+		{
+			hello: Hello{
+				Version:      "1.5.0",
+				Capabilities: &Capabilities{Encodings: []string{"xml"}},
+			},
+			ok: false,
+		},
+	}
+	for i, c := range cases {
+		h, err := Negotiate(c.hello)
+		if (err == nil) != c.ok {
+			t.Errorf("case %v: expected ok %v, got %v", i, c.ok, err)
+			continue
+		}
+		if !c.ok {
+			if _, is := err.(IncompatibleError); !is {
+				t.Errorf("case %v: expected IncompatibleError, got %T", i, err)
+			}
+			continue
+		}
+		if got := fmt.Sprint(h.Commands); got != c.commands {
+			t.Errorf("case %v: expected commands %v, got %v", i, c.commands, got)
+		}
+		if h.Extended != c.extended {
+			t.Errorf("case %v: expected extended %v, got %v", i, c.extended, h.Extended)
+		}
+		if h.Encoding != "json" {
+			t.Errorf("case %v: expected encoding json, got %v", i, h.Encoding)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	// Agents that do not advertise their capabilities get no reply.
+	legacy, _ := Negotiate(Hello{Version: "1.0.0"})
+	var buf bytes.Buffer
+	if err := legacy.Reply(&buf); err != nil || buf.Len() != 0 {
+		t.Errorf("expected no reply, got %q: %v", buf.String(), err)
+	}
+
+	h, _ := Negotiate(Hello{
+		Version: "1.0.0",
+		Capabilities: &Capabilities{
+			Messages:  []string{"profile", "event"},
+			Encodings: []string{"msgpack", "json"},
+			Commands:  []string{"emit"},
+		},
+	})
+	if err := h.Reply(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Encoding string
+		Messages []string
+		Commands []string
+	}
+	if err := json.Unmarshal(buf.Bytes(), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Encoding != "msgpack" || len(reply.Messages) != 2 || len(reply.Commands) != 1 {
+		t.Errorf("unexpected reply %q", buf.String())
+	}
+}
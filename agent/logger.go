@@ -2,22 +2,303 @@ package agent
 
 import (
 	"bufio"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// Logger is a remote logging connection server.
+// Level is the severity of an application log entry. The levels are those of
+// syslog, so that lower levels are more severe.
+type Level int
+
+// These are the log levels.
+const (
+	Emergency Level = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+var levelNames = [...]string{
+	Emergency: "emergency",
+	Alert:     "alert",
+	Critical:  "critical",
+	Error:     "error",
+	Warning:   "warning",
+	Notice:    "notice",
+	Info:      "info",
+	Debug:     "debug",
+}
+
+// levelAliases are other names in common use for levels.
+var levelAliases = map[string]Level{
+	"emerg": Emergency,
+	"panic": Emergency,
+	"fatal": Critical,
+	"crit":  Critical,
+	"err":   Error,
+	"warn":  Warning,
+	"trace": Debug,
+}
+
+func (l Level) String() string {
+	if l < Emergency || l > Debug {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s, in any case. Besides the names given
+// by String, it accepts common aliases such as "warn" and "fatal".
+func ParseLevel(s string) (Level, error) {
+	s = strings.ToLower(s)
+	for l, name := range levelNames {
+		if s == name {
+			return Level(l), nil
+		}
+	}
+	if l, ok := levelAliases[s]; ok {
+		return l, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// MarshalText encodes l as its name.
+func (l Level) MarshalText() ([]byte, error) { return []byte(l.String()), nil }
+
+// UnmarshalText decodes a level name, as accepted by ParseLevel.
+func (l *Level) UnmarshalText(b []byte) error {
+	v, err := ParseLevel(string(b))
+	if err != nil {
+		return err
+	}
+	*l = v
+	return nil
+}
+
+// LogEntry is the data of an "applog" message.
+type LogEntry struct {
+	Level   Level                      `json:"level"`
+	Message string                     `json:"message"`
+	Fields  map[string]json.RawMessage `json:"fields,omitempty"`
+}
+
+// parseLogLine interprets a line written by an app. A line holding a JSON
+// object is structured: its "level", "message" (or "msg") and "fields" keys
+// fill in the entry, and any other keys are added to the fields. A line that
+// starts with a syslog priority, such as "<3>", takes its level from it. Any
+// other line is a message at level def, as are structured lines that give no
+// level.
+func parseLogLine(line string, def Level) LogEntry {
+	if e, ok := parseJSONLine(line, def); ok {
+		return e
+	}
+	if l, rest, ok := parsePriority(line); ok {
+		return LogEntry{Level: l, Message: rest}
+	}
+	return LogEntry{Level: def, Message: line}
+}
+
+func parseJSONLine(line string, def Level) (LogEntry, bool) {
+	e := LogEntry{Level: def}
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return e, false
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(trimmed), &obj); err != nil {
+		return e, false
+	}
+	for k, v := range obj {
+		switch k {
+		case "level":
+			if l, ok := jsonLevel(v); ok {
+				e.Level = l
+				continue
+			}
+		case "message", "msg":
+			if err := json.Unmarshal(v, &e.Message); err == nil {
+				continue
+			}
+		case "fields":
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(v, &fields); err == nil {
+				for fk, fv := range fields {
+					e.field(fk, fv)
+				}
+				continue
+			}
+		}
+		// Keys that are not understood are kept as fields.
+		e.field(k, v)
+	}
+	return e, true
+}
+
+func (e *LogEntry) field(k string, v json.RawMessage) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]json.RawMessage)
+	}
+	e.Fields[k] = v
+}
+
+// jsonLevel decodes a level given either by name or as a syslog severity.
+func jsonLevel(v json.RawMessage) (Level, bool) {
+	var l Level
+	if err := json.Unmarshal(v, &l); err == nil {
+		return l, true
+	}
+	var n int
+	if err := json.Unmarshal(v, &n); err == nil && n >= int(Emergency) && n <= int(Debug) {
+		return Level(n), true
+	}
+	return 0, false
+}
+
+// parsePriority parses a syslog priority at the start of line, returning the
+// level it encodes and the rest of the line.
+func parsePriority(line string) (Level, string, bool) {
+	end := strings.IndexByte(line, '>')
+	if !strings.HasPrefix(line, "<") || end < 2 || end > 4 {
+		return 0, "", false
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, "", false
+	}
+	return Level(pri & 7), strings.TrimPrefix(line[end+1:], " "), true
+}
+
+// isContinuation reports whether line continues the previous entry, as the
+// indented lines of a stack trace do.
+func isContinuation(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// coalesceDelay is how long an entry is held for continuation lines.
+const coalesceDelay = 100 * time.Millisecond
+
+// maxEntry is the size in bytes beyond which no more lines are added to an
+// entry. Longer lines are split into pieces of this size.
+const maxEntry = 64 << 10
+
+// outputBacklog is the number of lines of an app's output that are held while
+// the client is behind; more are dropped.
+const outputBacklog = 1024
+
+// newScanner returns a Scanner of the lines of in.
+func newScanner(in io.Reader) *bufio.Scanner {
+	line := bufio.NewScanner(in)
+	line.Buffer(nil, maxEntry)
+	line.Split(scanLines)
+	return line
+}
+
+// scanLines is bufio.ScanLines, except that lines longer than maxEntry are
+// split into pieces, instead of ending the scan.
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= maxEntry {
+		return maxEntry, data[:maxEntry], nil
+	}
+	return advance, token, err
+}
+
+// rateLimit is a token bucket that allows rate entries per second, in bursts
+// of up to rate, and counts the entries it refuses.
+type rateLimit struct {
+	rate    float64
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+func newRateLimit(rate int) *rateLimit {
+	return &rateLimit{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// allow reports whether an entry can be sent at now.
+func (r *rateLimit) allow(now time.Time) bool {
+	r.tokens = math.Min(r.rate, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+	if r.tokens < 1 {
+		r.dropped++
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// Logger is a remote logging connection server. It reads lines written by an
+// app, and sends each entry they contain as an "applog" message whose data is
+// a LogEntry. Indented lines are added to the message of the entry before
+// them.
 type Logger struct {
-	line *bufio.Scanner
-	out  chan Message
+	in     io.Reader
+	line   *bufio.Scanner
+	min    Level
+	level  Level      // of lines that do not give one
+	stream string     // added to each entry as the field "stream", if not empty
+	limit  *rateLimit // nil if entries are not limited
+	diag   *diagnoser // nil unless the stream is standard error
+	out    chan Message
+
+	// If not nil, lines are dropped and counted here when the client is
+	// behind, so that the app never waits for it.
+	overflow *int64
 }
 
-// NewLogger returns a Logger that uses handler to convert data from in into
-// broker Messages.
-func NewLogger(in io.Reader) Logger {
+// NewLogger returns a Logger that reads from in, and sends entries at level
+// min or more severe.
+func NewLogger(in io.Reader, min Level) Logger {
 	l := Logger{
-		line: bufio.NewScanner(in),
-		out:  make(chan Message),
+		in:    in,
+		line:  newScanner(in),
+		min:   min,
+		level: Info,
+		out:   make(chan Message),
+	}
+	go l.serve()
+	return l
+}
+
+// NewOutputLogger returns a Logger for stream, the standard output or error of
+// an app, read from in. Lines that give no level of their own are at level.
+// Unless rate is 0, at most rate entries are sent per second; the number of
+// those dropped is sent in a Warning entry once entries can be sent again.
+//
+// If stream is "stderr", the Logger also sends a "diagnostic" message for each
+// Diagnostic found in it, whatever the level and rate limit.
+//
+// The Logger reads in as fast as the app writes it, whether or not its output
+// is read. Lines that arrive while more than outputBacklog are waiting are
+// dropped, and their number is sent in a Warning entry.
+func NewOutputLogger(in io.Reader, stream string, level, min Level, rate int) Logger {
+	l := Logger{
+		in:       in,
+		line:     newScanner(in),
+		min:      min,
+		level:    level,
+		stream:   stream,
+		out:      make(chan Message),
+		overflow: new(int64),
+	}
+	if rate > 0 {
+		l.limit = newRateLimit(rate)
+	}
+	if stream == "stderr" {
+		l.diag = &diagnoser{}
 	}
 	go l.serve()
 	return l
@@ -28,20 +309,129 @@ func (l Logger) serve() {
 	defer close(l.out)
 	log.Printf("Logger: accepted connection")
 	defer log.Printf("Logger: connection closed")
-	for l.line.Scan() {
-		l.out <- Message{
-			Type: "applog",
-			Data: l.line.Bytes(),
+
+	var lines chan string
+	if l.overflow == nil {
+		lines = make(chan string)
+	} else {
+		lines = make(chan string, outputBacklog)
+	}
+	go func() {
+		defer close(lines)
+		for l.line.Scan() {
+			if l.overflow == nil {
+				lines <- l.line.Text()
+				continue
+			}
+			select {
+			case lines <- l.line.Text():
+			default:
+				atomic.AddInt64(l.overflow, 1)
+			}
 		}
+	}()
+
+	var (
+		pending *LogEntry
+		timer   = time.NewTimer(coalesceDelay)
+	)
+	flush := func() {
+		e := pending
+		pending = nil
+		if e == nil || e.Level > l.min {
+			return
+		}
+		if l.limit != nil && !l.limit.allow(time.Now()) {
+			return
+		}
+		l.reportDropped()
+		l.send(*e)
 	}
-	if err := l.line.Err(); err != nil {
-		l.out <- Message{
-			Type:  "log",
-			Error: err.Error(),
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				l.reportDropped()
+				l.diagnose(l.diag.end())
+				if err := l.line.Err(); err != nil {
+					log.Printf("Logger: %v", err)
+					l.out <- Message{
+						Type:  "log",
+						Error: err.Error(),
+					}
+					// Keep the app from blocking on its writes.
+					go io.Copy(ioutil.Discard, l.in)
+				}
+				return
+			}
+			l.diagnose(l.diag.line(line))
+			if pending != nil && isContinuation(line) && len(pending.Message) < maxEntry {
+				pending.Message += "\n" + line
+			} else {
+				flush()
+				e := parseLogLine(line, l.level)
+				pending = &e
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(coalesceDelay)
+		case <-timer.C:
+			flush()
 		}
 	}
 }
 
+// send sends e, adding the name of l's stream.
+func (l Logger) send(e LogEntry) {
+	if l.stream != "" {
+		name, _ := json.Marshal(l.stream)
+		e.field("stream", name)
+	}
+	data, _ := json.Marshal(e)
+	l.out <- Message{Type: "applog", Data: data}
+}
+
+// diagnose sends a "diagnostic" message for each of diags.
+func (l Logger) diagnose(diags []Diagnostic) {
+	for _, d := range diags {
+		data, _ := json.Marshal(d)
+		l.out <- Message{Type: "diagnostic", Data: data}
+	}
+}
+
+// reportDropped sends the number of lines dropped while the client was
+// behind, and of entries dropped by l's rate limit, if any, since the last
+// report.
+func (l Logger) reportDropped() {
+	if l.overflow != nil {
+		if n := atomic.SwapInt64(l.overflow, 0); n > 0 {
+			l.sendDropped(int(n), "%v lines dropped while the client was behind")
+		}
+	}
+	if l.limit != nil && l.limit.dropped > 0 {
+		n := l.limit.dropped
+		l.limit.dropped = 0
+		l.sendDropped(n, "%v entries dropped by rate limit")
+	}
+}
+
+// sendDropped sends a Warning entry that n lines or entries were dropped, as
+// described by format.
+func (l Logger) sendDropped(n int, format string) {
+	l.send(LogEntry{
+		Level:   Warning,
+		Message: fmt.Sprintf(format, n),
+		Fields:  map[string]json.RawMessage{"dropped": json.RawMessage(strconv.Itoa(n))},
+	})
+}
+
 // Output returns l's output channel.
 func (l Logger) Output() <-chan Message {
 	return l.out
@@ -1,16 +1,20 @@
 package agent
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLogger(t *testing.T) {
 	data := `hello
 world`
 	r, w := io.Pipe()
-	logger := NewLogger(r)
+	logger := NewLogger(r, Debug)
 	if _, err := w.Write([]byte(data)); err != nil {
 		t.Error(err)
 	}
@@ -18,20 +22,20 @@ world`
 
 	m := <-logger.Output()
 	exp := Message{
-		Data: []byte("hello"),
+		Data: []byte(`{"level":"info","message":"hello"}`),
 		Type: "applog",
 	}
 	if !compare(m, exp) {
-		t.Errorf("expected %v, got %v", exp, m.Data)
+		t.Errorf("expected %v, got %v", exp, m)
 	}
 
 	m = <-logger.Output()
 	exp = Message{
-		Data: []byte("world"),
+		Data: []byte(`{"level":"info","message":"world"}`),
 		Type: "applog",
 	}
 	if !compare(m, exp) {
-		t.Errorf("expected %v, got %v", exp, m.Data)
+		t.Errorf("expected %v, got %v", exp, m)
 	}
 
 	m = <-logger.Output()
@@ -40,6 +44,142 @@ world`
 		Type:  "log",
 	}
 	if !compare(m, exp) {
-		t.Errorf("expected %v, got %v", exp, m.Data)
+		t.Errorf("expected %v, got %v", exp, m)
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	cases := []struct {
+		line   string
+		expect string
+	}{
+		{line: "plain", expect: `{"level":"info","message":"plain"}`},
+		{line: "<3>disk full", expect: `{"level":"error","message":"disk full"}`},
+		{line: "<12> low memory", expect: `{"level":"warning","message":"low memory"}`},
+		{line: "<999>not a priority", expect: `{"level":"info","message":"\u003c999\u003enot a priority"}`},
+		{
+			line:   `{"level":"WARN","msg":"slow","fields":{"ms":250},"user":"x"}`,
+			expect: `{"level":"warning","message":"slow","fields":{"ms":250,"user":"x"}}`,
+		},
+		{line: `{"level":2,"message":"down"}`, expect: `{"level":"critical","message":"down"}`},
+		{
+			line:   `{"level":"loud","message":"odd"}`,
+			expect: `{"level":"info","message":"odd","fields":{"level":"loud"}}`,
+		},
+		{line: `{"unterminated`, expect: `{"level":"info","message":"{\"unterminated"}`},
+	}
+	for i, c := range cases {
+		got, _ := json.Marshal(parseLogLine(c.line, Info))
+		if string(got) != c.expect {
+			t.Errorf("case %v: expected %v, got %s", i, c.expect, got)
+		}
+	}
+}
+
+func TestLoggerCoalesce(t *testing.T) {
+	in := strings.Join([]string{
+		"<7>noise",
+		"panic: boom",
+		"\tmain.go:10",
+		"\tmain.go:20",
+		`{"level":"error","message":"failed"}`,
+		"done",
+	}, "\n")
+	logger := NewLogger(strings.NewReader(in), Info)
+	var got []string
+	for m := range logger.Output() {
+		got = append(got, string(m.Data))
+	}
+	expect := []string{
+		`{"level":"info","message":"panic: boom\n\tmain.go:10\n\tmain.go:20"}`,
+		`{"level":"error","message":"failed"}`,
+		`{"level":"info","message":"done"}`,
+	}
+	if strings.Join(got, "|") != strings.Join(expect, "|") {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestOutputLogger(t *testing.T) {
+	in := "one\n<4>two\nthree\nfour\n"
+	logger := NewOutputLogger(strings.NewReader(in), "stderr", Error, Info, 2)
+	var got []string
+	for m := range logger.Output() {
+		got = append(got, string(m.Data))
+	}
+	expect := []string{
+		`{"level":"error","message":"one","fields":{"stream":"stderr"}}`,
+		`{"level":"warning","message":"two","fields":{"stream":"stderr"}}`,
+		`{"level":"warning","message":"2 entries dropped by rate limit","fields":{"dropped":2,"stream":"stderr"}}`,
+	}
+	if strings.Join(got, "|") != strings.Join(expect, "|") {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestLoggerLongLine(t *testing.T) {
+	in := strings.Repeat("x", maxEntry+10) + "\nshort\n"
+	logger := NewOutputLogger(strings.NewReader(in), "stdout", Info, Debug, 0)
+	var got []int
+	for m := range logger.Output() {
+		var e LogEntry
+		if err := json.Unmarshal(m.Data, &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, len(e.Message))
+	}
+	expect := []int{maxEntry, 10, len("short")}
+	if fmt.Sprint(got) != fmt.Sprint(expect) {
+		t.Errorf("expected entries of %v bytes, got %v", expect, got)
+	}
+}
+
+func TestOutputLoggerBehind(t *testing.T) {
+	// Nobody reads the output of the logger until the app has written
+	// more lines than it holds.
+	r, w := io.Pipe()
+	logger := NewOutputLogger(r, "stdout", Info, Debug, 0)
+	n := outputBacklog + 100
+	written := make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(w, "line %v\n", i)
+		}
+		w.Close()
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected writes not to wait for the logger's output to be read")
+	}
+
+	var entries, dropped int
+	for m := range logger.Output() {
+		var e LogEntry
+		if err := json.Unmarshal(m.Data, &e); err != nil {
+			t.Fatal(err)
+		}
+		if d, ok := e.Fields["dropped"]; ok {
+			fmt.Sscan(string(d), &dropped)
+			continue
+		}
+		entries++
+	}
+	if dropped == 0 || entries+dropped != n {
+		t.Errorf("expected %v lines, sent or dropped, got %v sent and %v dropped", n, entries, dropped)
+	}
+}
+
+func TestLoggerDiagnostics(t *testing.T) {
+	in := "prog: a.c:5: main: Assertion `x' failed.\n"
+	logger := NewOutputLogger(strings.NewReader(in), "stderr", Error, Emergency, 0)
+	var types []string
+	for m := range logger.Output() {
+		types = append(types, m.Type)
+	}
+	// The entry is below the minimum level, but the diagnostic is sent.
+	if strings.Join(types, " ") != "diagnostic" {
+		t.Errorf("expected [diagnostic], got %v", types)
 	}
 }
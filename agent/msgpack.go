@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// decodeJSON decodes a message encoded in JSON.
+func decodeJSON(rec []byte) (Message, error) {
+	var msg Message
+	err := json.Unmarshal(rec, &msg)
+	return msg, err
+}
+
+// decodeMsgPack decodes a message encoded in msgpack: a map holding a type
+// and data, like the JSON messages. The data of profiles is kept as it is;
+// the data of other messages is converted to JSON.
+func decodeMsgPack(rec []byte) (Message, error) {
+	var (
+		msg  Message
+		data []byte
+	)
+	r := bytes.NewReader(rec)
+	dec := msgpack.NewDecoder(r)
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return Message{}, err
+	}
+	for i := 0; i < n; i++ {
+		key, err := dec.DecodeString()
+		if err != nil {
+			return Message{}, err
+		}
+		switch key {
+		case "type":
+			msg.Type, err = dec.DecodeString()
+		case "data":
+			start := len(rec) - r.Len()
+			err = dec.Skip()
+			data = rec[start : len(rec)-r.Len()]
+		default:
+			err = dec.Skip()
+		}
+		if err != nil {
+			return Message{}, err
+		}
+	}
+
+	if msg.Type == "profile" {
+		msg.MsgPack = data
+		return msg, nil
+	}
+	if data != nil {
+		var v interface{}
+		if err := msgpack.Unmarshal(data, &v); err != nil {
+			return Message{}, err
+		}
+		if msg.Data, err = json.Marshal(v); err != nil {
+			return Message{}, err
+		}
+	}
+	return msg, nil
+}
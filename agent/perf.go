@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Sample is a measurement of the resource usage of an app's process.
+type Sample struct {
+	Time       int64   `json:"timestamp"`  // Unix milliseconds
+	RSS        uint64  `json:"rssBytes"`   // resident set size
+	CPUTime    int64   `json:"cpuMs"`      // user and system time since the process started
+	CPUPercent float64 `json:"cpuPercent"` // of one core, since the previous sample
+}
+
+// PerfIssue is the data of a "performance" message, which a PerfMonitor sends
+// when the usage of an app breaks one of its rules.
+type PerfIssue struct {
+	Kind    string   `json:"kind"` // "memory-leak" or "cpu-runaway"
+	Summary string   `json:"summary"`
+	Samples []Sample `json:"samples"` // the most recent, oldest first
+}
+
+// PerfRules configure a PerfMonitor. A rule with a zero threshold is not
+// checked.
+type PerfRules struct {
+	Period time.Duration // between samples
+
+	// The memory of an app is leaking when its resident set grows by
+	// more than LeakGrowth percent per hour, measured over LeakWindow.
+	LeakGrowth float64
+	LeakWindow time.Duration
+
+	// The CPU usage of an app has run away when it stays above
+	// CPUThreshold percent of one core for CPUDuration.
+	CPUThreshold float64
+	CPUDuration  time.Duration
+}
+
+// maxIssueSamples is the number of samples sent with a PerfIssue.
+const maxIssueSamples = 60
+
+// PerfMonitor watches the resource usage of an app for trends that indicate
+// a problem.
+type PerfMonitor struct {
+	rules  PerfRules
+	sample func() (Sample, error)
+	e      Emitter
+	done   <-chan struct{}
+	out    chan Message
+
+	samples  []Sample // within the longest window of the rules
+	first    int64    // time of the first sample
+	hot      bool     // the CPU usage is high
+	hotSince int64    // since when
+	leaking  bool     // a leak was reported, and growth has not yet slowed
+	runaway  bool     // a runaway was reported, and usage has not yet fallen
+}
+
+// NewPerfMonitor returns a PerfMonitor that calls sample every period of
+// rules, until done closes. It sends a "performance" message once each time
+// the app starts breaking a rule, and, if e is not nil, then requests a
+// profile with e, so that the issue can be seen in it.
+func NewPerfMonitor(rules PerfRules, sample func() (Sample, error), e Emitter, done <-chan struct{}) *PerfMonitor {
+	p := &PerfMonitor{
+		rules:  rules,
+		sample: sample,
+		e:      e,
+		done:   done,
+		out:    make(chan Message),
+	}
+	go p.serve()
+	return p
+}
+
+// Output returns p's output stream.
+func (p *PerfMonitor) Output() <-chan Message { return p.out }
+
+func (p *PerfMonitor) serve() {
+	defer close(p.out)
+	tick := time.NewTicker(p.rules.Period)
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-tick.C:
+		}
+		s, err := p.sample()
+		if err != nil {
+			// The process may have exited.
+			continue
+		}
+		for _, issue := range p.add(s) {
+			data, _ := json.Marshal(issue)
+			select {
+			case p.out <- Message{Type: "performance", Data: data}:
+			case <-p.done:
+				return
+			}
+			if p.e != nil {
+				p.e.Emit()
+			}
+		}
+	}
+}
+
+// add adds s to the samples of p, and returns any issues that it reveals.
+func (p *PerfMonitor) add(s Sample) []PerfIssue {
+	if n := len(p.samples); n > 0 {
+		prev := p.samples[n-1]
+		if s.Time <= prev.Time {
+			return nil
+		}
+		s.CPUPercent = float64(s.CPUTime-prev.CPUTime) / float64(s.Time-prev.Time) * 100
+	} else {
+		p.first = s.Time
+	}
+	p.samples = append(p.samples, s)
+	keep := p.rules.LeakWindow
+	if p.rules.CPUDuration > keep {
+		keep = p.rules.CPUDuration
+	}
+	for len(p.samples) > 1 && p.samples[0].Time < s.Time-ms(keep) {
+		p.samples = p.samples[1:]
+	}
+
+	var issues []PerfIssue
+	if summary, ok := p.checkLeak(); ok {
+		issues = append(issues, p.issue("memory-leak", summary))
+	}
+	if summary, ok := p.checkCPU(); ok {
+		issues = append(issues, p.issue("cpu-runaway", summary))
+	}
+	return issues
+}
+
+// checkLeak returns a summary of a leak, if one started with the last sample.
+func (p *PerfMonitor) checkLeak() (string, bool) {
+	if p.rules.LeakGrowth <= 0 {
+		return "", false
+	}
+	last := p.samples[len(p.samples)-1]
+	if last.Time-p.first < ms(p.rules.LeakWindow) {
+		// Not enough has been seen yet.
+		return "", false
+	}
+	growth := p.growth(last.Time - ms(p.rules.LeakWindow))
+	if growth < p.rules.LeakGrowth {
+		p.leaking = false
+		return "", false
+	}
+	if p.leaking {
+		return "", false
+	}
+	p.leaking = true
+	return fmt.Sprintf("resident set grew by %.1f%% per hour over %v", growth, p.rules.LeakWindow), true
+}
+
+// growth returns the rate of growth, in percent per hour, of the resident set
+// over the samples since from. It is the slope of the least-squares line
+// through them, relative to their mean.
+func (p *PerfMonitor) growth(from int64) float64 {
+	var n, sumT, sumR, sumTT, sumTR float64
+	for _, s := range p.samples {
+		if s.Time < from {
+			continue
+		}
+		t := float64(s.Time-from) / float64(time.Hour/time.Millisecond)
+		r := float64(s.RSS)
+		n++
+		sumT += t
+		sumR += r
+		sumTT += t * t
+		sumTR += t * r
+	}
+	d := n*sumTT - sumT*sumT
+	if n < 2 || d == 0 || sumR == 0 {
+		return 0
+	}
+	slope := (n*sumTR - sumT*sumR) / d
+	return slope / (sumR / n) * 100
+}
+
+// checkCPU returns a summary of a runaway, if one started with the last
+// sample.
+func (p *PerfMonitor) checkCPU() (string, bool) {
+	n := len(p.samples)
+	if p.rules.CPUThreshold <= 0 || n < 2 {
+		return "", false
+	}
+	last := p.samples[n-1]
+	if last.CPUPercent < p.rules.CPUThreshold {
+		p.hot, p.runaway = false, false
+		return "", false
+	}
+	if !p.hot {
+		p.hot, p.hotSince = true, p.samples[n-2].Time
+	}
+	if p.runaway || last.Time-p.hotSince < ms(p.rules.CPUDuration) {
+		return "", false
+	}
+	p.runaway = true
+	return fmt.Sprintf("CPU usage above %v%% of one core for %v", p.rules.CPUThreshold, p.rules.CPUDuration), true
+}
+
+// issue returns an issue of kind, with the most recent samples.
+func (p *PerfMonitor) issue(kind, summary string) PerfIssue {
+	samples := p.samples
+	if len(samples) > maxIssueSamples {
+		samples = samples[len(samples)-maxIssueSamples:]
+	}
+	return PerfIssue{
+		Kind:    kind,
+		Summary: summary,
+		Samples: append([]Sample(nil), samples...),
+	}
+}
+
+// ms returns d in milliseconds.
+func ms(d time.Duration) int64 { return int64(d / time.Millisecond) }
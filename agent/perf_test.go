@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPerfMonitorRules(t *testing.T) {
+	rules := PerfRules{
+		LeakGrowth:   20,
+		LeakWindow:   time.Hour,
+		CPUThreshold: 90,
+		CPUDuration:  10 * time.Minute,
+	}
+	const minute = int64(time.Minute / time.Millisecond)
+	cases := []struct {
+		rss    func(i int64) uint64 // in minute i
+		cpu    func(i int64) int64  // CPU ms used in minute i
+		expect []string             // kinds of issue, by minute
+	}{
+		{
+			// Steady usage is fine.
+			rss:    func(i int64) uint64 { return 1000 },
+			cpu:    func(i int64) int64 { return minute / 2 },
+			expect: nil,
+		},
+		{
+			// Memory growing by 1% of its start a minute is reported
+			// once a whole window has been seen.
+			rss:    func(i int64) uint64 { return 1000 + 10*uint64(i) },
+			cpu:    func(i int64) int64 { return 0 },
+			expect: []string{"60:memory-leak"},
+		},
+		{
+			// A busy core is reported after ten minutes, and again
+			// ten minutes after it rests.
+			rss: func(i int64) uint64 { return 1000 },
+			cpu: func(i int64) int64 {
+				if i == 30 {
+					return 0
+				}
+				return minute
+			},
+			expect: []string{"10:cpu-runaway", "40:cpu-runaway"},
+		},
+	}
+	for i, c := range cases {
+		p := &PerfMonitor{rules: rules}
+		var cpu int64
+		var got []string
+		for m := int64(0); m <= 90; m++ {
+			cpu += c.cpu(m)
+			for _, issue := range p.add(Sample{Time: m * minute, RSS: c.rss(m), CPUTime: cpu}) {
+				got = append(got, fmt.Sprintf("%v:%v", m, issue.Kind))
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.expect) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
+		}
+	}
+}
+
+type countingEmitter struct{ n int32 }
+
+func (e *countingEmitter) Emit() error {
+	atomic.AddInt32(&e.n, 1)
+	return nil
+}
+
+func TestPerfMonitor(t *testing.T) {
+	var now int64
+	sample := func() (Sample, error) {
+		// Each sample is a second later, and uses the whole second.
+		now += 1000
+		return Sample{Time: now, RSS: 1000, CPUTime: now}, nil
+	}
+	done := make(chan struct{})
+	var e countingEmitter
+	p := NewPerfMonitor(PerfRules{
+		Period:       time.Millisecond,
+		CPUThreshold: 90,
+		CPUDuration:  5 * time.Second,
+	}, sample, &e, done)
+
+	msg := <-p.Output()
+	var issue PerfIssue
+	if err := json.Unmarshal(msg.Data, &issue); msg.Type != "performance" || err != nil {
+		t.Fatalf("expected performance issue, got %v: %v", msg, err)
+	}
+	if issue.Kind != "cpu-runaway" || len(issue.Samples) < 6 || issue.Samples[len(issue.Samples)-1].CPUPercent != 100 {
+		t.Errorf("unexpected issue %s", msg.Data)
+	}
+	close(done)
+	if _, open := <-p.Output(); open {
+		t.Error("expected output to close")
+	}
+	if n := atomic.LoadInt32(&e.n); n != 1 {
+		t.Errorf("expected 1 profile request, got %v", n)
+	}
+}
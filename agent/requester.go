@@ -1,7 +1,6 @@
 package agent
 
 import (
-	"io"
 	"time"
 
 	"github.com/aukletio/Auklet-Client-C/broker"
@@ -10,17 +9,17 @@ import (
 // PeriodicRequester periodically sends emission requests over a connection.
 type PeriodicRequester struct {
 	conf <-chan int // provides the period in seconds; should never be closed
-	conn io.Writer  // the connection on which to emit requests
+	cmd  Emitter    // sends the emission requests
 	out  chan broker.Message
 	done <-chan struct{} // cancellation requests
 }
 
-// NewPeriodicRequester creates a PeriodicRequester that sends requests over
-// conn. When done closes, the requester closes its output and terminates.
-func NewPeriodicRequester(conn io.Writer, done <-chan struct{}, conf <-chan int) PeriodicRequester {
+// NewPeriodicRequester creates a PeriodicRequester that sends requests with
+// cmd. When done closes, the requester closes its output and terminates.
+func NewPeriodicRequester(cmd Emitter, done <-chan struct{}, conf <-chan int) PeriodicRequester {
 	r := PeriodicRequester{
 		conf: conf,
-		conn: conn,
+		cmd:  cmd,
 		out:  make(chan broker.Message),
 		done: done,
 	}
@@ -42,7 +41,7 @@ func (r PeriodicRequester) run() {
 		case <-r.done:
 			return
 		case <-emit.C:
-			if _, err := r.conn.Write([]byte{0}); err != nil {
+			if err := r.cmd.Emit(); err != nil {
 				if prevErr != nil {
 					// This is our second write error. A
 					// single write error sometimes happens
@@ -7,10 +7,13 @@ import (
 	"time"
 )
 
+// legacy is the handshake of an agent that only takes emission requests.
+var legacy = Handshake{Commands: []string{Emit}}
+
 func TestRequester(t *testing.T) {
 	r, w := io.Pipe()
 	conf := make(chan int)
-	req := NewPeriodicRequester(w, nil, conf)
+	req := NewPeriodicRequester(NewCommander(w, legacy), nil, conf)
 	conf <- 1
 	buf := make([]byte, 1)
 	n, err := r.Read(buf)
@@ -30,7 +33,7 @@ func TestRequester(t *testing.T) {
 
 func TestRequesterDone(t *testing.T) {
 	done := make(chan struct{})
-	req := NewPeriodicRequester(&bytes.Buffer{}, done, nil)
+	req := NewPeriodicRequester(NewCommander(&bytes.Buffer{}, legacy), done, nil)
 	// terminate the requester
 	close(done)
 	if _, open := <-req.Output(); open {
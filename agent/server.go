@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"time"
 )
 
 // Message represents messages that can be received by a Server, and thus,
@@ -15,26 +15,93 @@ type Message struct {
 	Type  string          `json:"type"`
 	Data  json.RawMessage `json:"data"`
 	Error string
+
+	// MsgPack holds the data of profiles from agents that use the
+	// msgpack encoding, in place of Data, so that it need not be
+	// re-encoded.
+	MsgPack []byte `json:"-"`
+}
+
+// Limits bound the resources that a Server spends on an agent. Fields left at
+// zero take default values.
+type Limits struct {
+	MaxMessageSize int           // in bytes; larger messages are skipped
+	Buffer         int           // messages held while the consumer is busy
+	ReportPeriod   time.Duration // least time between reports of Stats
+}
+
+// These are the default Limits.
+const (
+	DefaultMaxMessageSize = 4 << 20
+	DefaultBuffer         = 64
+	DefaultReportPeriod   = time.Minute
+)
+
+// Stats counts the messages that a Server could not pass on. They are sent,
+// as the data of a message of type "stats", whenever they change, but no more
+// than once per report period, and when the agent disconnects.
+type Stats struct {
+	Oversized int `json:"oversized"` // messages over the maximum size
+	Malformed int `json:"malformed"` // messages, or data between them, that could not be decoded
+	Dropped   int `json:"dropped"`   // messages dropped because the buffer was full
+}
+
+// droppable lists the types of message that are dropped, rather than waited
+// for, when the buffer is full. Losing a profile costs little; losing an event
+// would lose a crash.
+var droppable = map[string]bool{
+	"profile": true,
+	"log":     true,
 }
 
 // Server provides a connection server for an Auklet agent.
 type Server struct {
-	in  io.Reader
-	dec *json.Decoder
-	out chan Message
+	frames interface {
+		next() ([]byte, error)
+	}
+	decode func([]byte) (Message, error)
+	lim    Limits
+	out    chan Message
 	// Done closes when the Server gets EOF.
 	Done chan struct{}
+
+	stats      Stats
+	reported   Stats     // as of the last report
+	reportedAt time.Time // time of the last report
 }
 
-// NewServer returns a new Server that reads from in. If dec is not nil, it is
-// used directly.
-func NewServer(in io.Reader, dec *json.Decoder) *Server {
+// NewServer returns a new Server that reads messages in the encoding agreed
+// in h from in, within the bounds of lim. If dec is not nil, it is the
+// decoder that read the agent's hello, and the data it buffered is read
+// first.
+//
+// JSON messages follow each other. Msgpack messages are each preceded by
+// their length, as a 4-byte big-endian integer.
+func NewServer(in io.Reader, dec *json.Decoder, h Handshake, lim Limits) *Server {
+	if dec != nil {
+		in = io.MultiReader(dec.Buffered(), in)
+	}
+	if lim.MaxMessageSize <= 0 {
+		lim.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if lim.Buffer <= 0 {
+		lim.Buffer = DefaultBuffer
+	}
+	if lim.ReportPeriod <= 0 {
+		lim.ReportPeriod = DefaultReportPeriod
+	}
 	s := &Server{
-		in:   in,
-		dec:  dec,
-		out:  make(chan Message),
+		lim:  lim,
+		out:  make(chan Message, lim.Buffer),
 		Done: make(chan struct{}),
 	}
+	if h.Encoding == "msgpack" {
+		s.frames = newLengthFrameReader(in, lim.MaxMessageSize)
+		s.decode = decodeMsgPack
+	} else {
+		s.frames = newFrameReader(in, lim.MaxMessageSize)
+		s.decode = decodeJSON
+	}
 	go s.serve()
 	return s
 }
@@ -46,33 +113,86 @@ func (s *Server) serve() {
 	defer close(s.out)
 	log.Print("Server: accepted connection")
 	defer log.Print("Server: connection closed")
-	if s.dec == nil {
-		s.dec = json.NewDecoder(s.in)
-	}
 	errd := false
+read:
 	for {
-		var msg Message
-		if err := s.dec.Decode(&msg); err == io.EOF {
+		rec, err := s.frames.next()
+		if err == io.EOF {
 			break
-		} else if err != nil {
-			// There was a problem decoding the stream into
-			// message format.
-			buf, _ := ioutil.ReadAll(s.dec.Buffered())
-			s.out <- Message{
-				Type:  "log",
-				Error: fmt.Sprintf("%v in %v", err.Error(), string(buf)),
+		}
+		var msg Message
+		switch err.(type) {
+		case nil:
+			if msg, err = s.decode(rec); err != nil {
+				s.stats.Malformed++
+				msg = logMessage(fmt.Sprintf("%v in %s", err, quote(rec)))
+			}
+		case tooLarge:
+			s.stats.Oversized++
+			msg = logMessage(err.Error())
+		case garbage:
+			s.stats.Malformed++
+			msg = logMessage(err.Error())
+		default:
+			if err != io.ErrUnexpectedEOF {
+				s.send(logMessage(err.Error()))
+				break read
 			}
-			s.dec = json.NewDecoder(s.in)
-			continue
+			// The agent disconnected in the middle of a message.
+			s.stats.Malformed++
+			msg = logMessage(fmt.Sprintf("%v in %s", err, quote(rec)))
 		}
 		if msg.Type == "event" {
 			errd = true
 		}
-		s.out <- msg
+		s.send(msg)
+		s.report(false)
 	}
 	if !errd {
-		s.out <- Message{Type: "cleanExit"}
+		s.send(Message{Type: "cleanExit"})
+	}
+	s.report(true)
+}
+
+// logMessage returns a message reporting a problem with the stream.
+func logMessage(err string) Message {
+	return Message{Type: "log", Error: err}
+}
+
+// quote returns the start of rec, for error messages.
+func quote(rec []byte) []byte {
+	if len(rec) > maxQuote {
+		return append(rec[:maxQuote:maxQuote], "..."...)
+	}
+	return rec
+}
+
+// send passes msg on, unless the buffer is full and msg can be dropped.
+func (s *Server) send(msg Message) {
+	if !droppable[msg.Type] {
+		s.out <- msg
+		return
+	}
+	select {
+	case s.out <- msg:
+	default:
+		s.stats.Dropped++
+	}
+}
+
+// report sends the stats of s if they changed, and the report period has
+// passed since the last report or final is true.
+func (s *Server) report(final bool) {
+	if s.stats == s.reported {
+		return
+	}
+	if !final && time.Since(s.reportedAt) < s.lim.ReportPeriod {
+		return
 	}
+	data, _ := json.Marshal(s.stats)
+	s.out <- Message{Type: "stats", Data: data}
+	s.reported = s.stats
+	s.reportedAt = time.Now()
 }
 
 // Output returns s's output stream.
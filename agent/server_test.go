@@ -2,8 +2,14 @@ package agent
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
 )
 
 type testServerCase struct {
@@ -53,10 +59,83 @@ func TestServer(t *testing.T) {
 		},
 	}
 	for _, c := range cases {
-		s := NewServer(bytes.NewBuffer(c.input), nil)
+		s := NewServer(bytes.NewBuffer(c.input), nil, Handshake{}, Limits{})
 		got := <-s.Output()
 		if !compare(got, c.expect) {
 			t.Errorf("expected %v, got %v", c.expect, got)
 		}
 	}
 }
+
+func TestServerLimits(t *testing.T) {
+	var input bytes.Buffer
+	for i := 0; i < 5; i++ {
+		input.WriteString(`{"type":"profile","data":{}}`)
+	}
+	input.WriteString(`{"type":"profile","data":"` + strings.Repeat("x", 100) + `"}`)
+	input.WriteString(`{"type":"event","data":{}}`)
+
+	s := NewServer(&input, nil, Handshake{}, Limits{MaxMessageSize: 64, Buffer: 2})
+	// Let the buffer fill up.
+	time.Sleep(50 * time.Millisecond)
+
+	var profiles, logs, events int
+	var stats Stats
+	for msg := range s.Output() {
+		switch msg.Type {
+		case "profile":
+			profiles++
+		case "log":
+			logs++
+		case "event":
+			events++
+		case "stats":
+			if err := json.Unmarshal(msg.Data, &stats); err != nil {
+				t.Error(err)
+			}
+		case "cleanExit":
+			t.Error("unexpected cleanExit after event")
+		}
+	}
+	if events != 1 {
+		t.Errorf("expected 1 event, got %v", events)
+	}
+	// The oversized message is reported in a log message, which may itself
+	// be dropped.
+	if stats.Dropped == 0 || stats.Dropped+profiles+logs != 6 {
+		t.Errorf("expected dropped and passed messages to add up to 6, got %v, %v and %v", stats.Dropped, profiles, logs)
+	}
+	if stats.Oversized != 1 {
+		t.Errorf("expected 1 oversized message, got %v", stats.Oversized)
+	}
+}
+
+func TestServerMsgPack(t *testing.T) {
+	var input bytes.Buffer
+	for _, msg := range []interface{}{
+		map[string]interface{}{"type": "profile", "data": map[string]interface{}{"tree": []int{1, 2}}},
+		map[string]interface{}{"type": "event", "data": map[string]interface{}{"signal": "SIGSEGV"}},
+		"not a map",
+	} {
+		b, err := msgpack.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.Write(&input, binary.BigEndian, uint32(len(b)))
+		input.Write(b)
+	}
+
+	s := NewServer(&input, nil, Handshake{Encoding: "msgpack"}, Limits{})
+	profile := <-s.Output()
+	var tree map[string][]int
+	if err := msgpack.Unmarshal(profile.MsgPack, &tree); profile.Type != "profile" || err != nil || len(tree["tree"]) != 2 {
+		t.Errorf("expected profile data to be kept in msgpack, got %v: %v", profile, err)
+	}
+	event := <-s.Output()
+	if event.Type != "event" || string(event.Data) != `{"signal":"SIGSEGV"}` {
+		t.Errorf("expected event data in JSON, got %v", event)
+	}
+	if msg := <-s.Output(); msg.Type != "log" || msg.Error == "" {
+		t.Errorf("expected error, got %v", msg)
+	}
+}
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Emitter sends emission requests to an agent.
+type Emitter interface {
+	Emit() error
+}
+
+// Hang is the data of a "hang" message, which a Watchdog sends in place of an
+// agent that stopped answering emission requests.
+type Hang struct {
+	Waited    int64           `json:"waitedMs"`            // since the oldest unanswered request
+	Process   json.RawMessage `json:"process,omitempty"`   // state of the process
+	StackDump json.RawMessage `json:"stackDump,omitempty"` // result of the StackDump command
+}
+
+// Watchdog detects a hung app: one whose agent does not follow an emission
+// request with a profile within a window.
+type Watchdog struct {
+	window  time.Duration
+	inspect func(*Hang)   // completes a Hang; may take some time
+	armed   chan struct{} // signals that a request is awaiting a profile
+
+	mu    sync.Mutex
+	since time.Time // of the oldest unanswered request; zero if none
+}
+
+// NewWatchdog returns a Watchdog that waits for window after each request.
+// When that passes without a profile, inspect is called to describe the app,
+// and a "hang" message is sent. No further hang is reported until a profile
+// arrives.
+func NewWatchdog(window time.Duration, inspect func(*Hang)) *Watchdog {
+	return &Watchdog{
+		window:  window,
+		inspect: inspect,
+		armed:   make(chan struct{}, 1),
+	}
+}
+
+// Emitter returns an Emitter that sends requests with e, and reports them to
+// w.
+func (w *Watchdog) Emitter(e Emitter) Emitter { return watchedEmitter{e, w} }
+
+type watchedEmitter struct {
+	e Emitter
+	w *Watchdog
+}
+
+func (we watchedEmitter) Emit() error {
+	if err := we.e.Emit(); err != nil {
+		return err
+	}
+	we.w.requested()
+	return nil
+}
+
+// requested notes a request, unless an earlier one is still unanswered.
+func (w *Watchdog) requested() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.since.IsZero() {
+		return
+	}
+	w.since = time.Now()
+	select {
+	case w.armed <- struct{}{}:
+	default:
+	}
+}
+
+// answered notes that a profile arrived.
+func (w *Watchdog) answered() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.since = time.Time{}
+}
+
+// oldest returns the time of the oldest unanswered request, or zero if there
+// is none.
+func (w *Watchdog) oldest() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.since
+}
+
+// Watch returns a MessageSource that passes on the messages of in, adding a
+// "hang" message whenever the agent is found to be hung.
+func (w *Watchdog) Watch(in MessageSource) MessageSource {
+	s := watch{in: in, out: make(chan Message), w: w}
+	go s.serve()
+	return s
+}
+
+type watch struct {
+	in  MessageSource
+	out chan Message
+	w   *Watchdog
+}
+
+// Output returns the messages of s's input, and any "hang" messages.
+func (s watch) Output() <-chan Message { return s.out }
+
+func (s watch) serve() {
+	defer close(s.out)
+	var (
+		timer      *time.Timer
+		expired    <-chan time.Time
+		hung       bool
+		inspecting bool
+		hangs      = make(chan Message, 1)
+	)
+	for {
+		select {
+		case msg, ok := <-s.in.Output():
+			if !ok {
+				// A hang is often ended by the app being
+				// killed; it must still be reported.
+				if inspecting {
+					s.out <- <-hangs
+				}
+				return
+			}
+			if msg.Type == "profile" {
+				s.w.answered()
+				if timer != nil {
+					timer.Stop()
+				}
+				expired, hung = nil, false
+			}
+			s.out <- msg
+		case <-s.w.armed:
+			since := s.w.oldest()
+			if hung || expired != nil || since.IsZero() {
+				continue
+			}
+			timer = time.NewTimer(s.w.window - time.Since(since))
+			expired = timer.C
+		case <-expired:
+			expired = nil
+			since := s.w.oldest()
+			if since.IsZero() {
+				continue
+			}
+			hung, inspecting = true, true
+			h := Hang{Waited: int64(time.Since(since) / time.Millisecond)}
+			go func() {
+				s.w.inspect(&h)
+				b, _ := json.Marshal(h)
+				hangs <- Message{Type: "hang", Data: b}
+			}()
+		case msg := <-hangs:
+			inspecting = false
+			s.out <- msg
+		}
+	}
+}
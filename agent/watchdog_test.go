@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type nopEmitter struct{}
+
+func (nopEmitter) Emit() error { return nil }
+
+func TestWatchdog(t *testing.T) {
+	in := make(source)
+	w := NewWatchdog(20*time.Millisecond, func(h *Hang) {
+		h.Process = json.RawMessage(`{"pid":1}`)
+	})
+	out := w.Watch(in).Output()
+	e := w.Emitter(nopEmitter{})
+
+	// A request followed by a profile in time is no hang.
+	e.Emit()
+	in <- Message{Type: "profile"}
+	if msg := <-out; msg.Type != "profile" {
+		t.Errorf("expected profile, got %v", msg.Type)
+	}
+	select {
+	case msg := <-out:
+		t.Errorf("expected no message, got %v", msg.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// An unanswered request is.
+	e.Emit()
+	msg := <-out
+	var h Hang
+	if err := json.Unmarshal(msg.Data, &h); msg.Type != "hang" || err != nil {
+		t.Fatalf("expected hang, got %v: %v", msg.Type, err)
+	}
+	if h.Waited < 20 || string(h.Process) != `{"pid":1}` {
+		t.Errorf("unexpected hang %s", msg.Data)
+	}
+
+	// It is reported once, until a profile arrives.
+	e.Emit()
+	select {
+	case msg := <-out:
+		t.Errorf("expected no message, got %v", msg.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+	in <- Message{Type: "profile"}
+	<-out
+	e.Emit()
+	if msg := <-out; msg.Type != "hang" {
+		t.Errorf("expected hang, got %v", msg.Type)
+	}
+
+	close(in)
+	if _, open := <-out; open {
+		t.Error("expected output to close")
+	}
+}
+
+func TestWatchdogKilled(t *testing.T) {
+	// The inspection of a hang may end the app.
+	in := make(source)
+	w := NewWatchdog(time.Millisecond, func(*Hang) { close(in) })
+	out := w.Watch(in).Output()
+	w.Emitter(nopEmitter{}).Emit()
+	if msg := <-out; msg.Type != "hang" {
+		t.Errorf("expected hang, got %v", msg.Type)
+	}
+	if _, open := <-out; open {
+		t.Error("expected output to close")
+	}
+}
@@ -77,6 +77,13 @@ func (err errNotReleased) Error() string {
 	return fmt.Sprintf("not released: %v", err.checksum)
 }
 
+// NotReleased reports whether err indicates that a checksum has not been
+// released.
+func NotReleased(err error) bool {
+	_, ok := err.(errNotReleased)
+	return ok
+}
+
 // Certificates retrieves CA certs.
 func (a API) Certificates() (*tls.Config, error) {
 	url := a.BaseURL + a.CertificatesEP
@@ -155,12 +162,17 @@ func decodeCredentials(data []byte) (*Credentials, error) {
 	}
 
 	if c.Password == "" {
-		return nil, errors.New("empty password")
+		return nil, ErrEmptyPassword
 	}
 
 	return c, nil
 }
 
+// ErrEmptyPassword indicates that the API returned credentials without a
+// password, as it does when a device's credentials are requested more than
+// once.
+var ErrEmptyPassword = errors.New("empty password")
+
 // Credentialer provides a way to get Credentials.
 type Credentialer interface {
 	Credentials() (*Credentials, error)
@@ -317,6 +329,15 @@ func (err errStatus) Error() string {
 	return fmt.Sprintf("unexpected status: %v from %v", err.resp.Status, err.resp.Request.URL)
 }
 
+// StatusCode returns the HTTP status code of the unexpected response reported
+// by err, or 0 if err does not report one.
+func StatusCode(err error) int {
+	if e, ok := err.(errStatus); ok {
+		return e.resp.StatusCode
+	}
+	return 0
+}
+
 type errEncoding struct {
 	Err  error
 	What string
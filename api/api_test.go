@@ -127,8 +127,8 @@ func TestRelease(t *testing.T) {
 		ReleasesEP: ReleasesEP,
 	}
 
-	if err := api.Release(""); err == nil {
-		t.Fail()
+	if err := api.Release(""); !NotReleased(err) {
+		t.Errorf("expected not released, got %v", err)
 	}
 
 	if err := api.Release("valid"); err != nil {
@@ -181,3 +181,22 @@ func TestDataLimit(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestStatusCode(t *testing.T) {
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	api := API{
+		BaseURL:     s.URL,
+		DataLimitEP: DataLimitEP,
+		AppID:       "unknown",
+	}
+
+	_, err := api.DataLimit()
+	if code := StatusCode(err); code != http.StatusNotFound {
+		t.Errorf("expected %v, got %v: %v", http.StatusNotFound, code, err)
+	}
+	if code := StatusCode(errParseCA); code != 0 {
+		t.Errorf("expected 0, got %v", code)
+	}
+}
@@ -0,0 +1,448 @@
+// +build linux
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aukletio/Auklet-Client-C/agent"
+)
+
+// hello is the first message on a connection to a Listener. An app connects
+// once for its agent data, and optionally once more for its logs.
+type hello struct {
+	agent.Hello        // version and capabilities; not needed for logs
+	Pid         int    `json:"pid"`    // if given, must be that of the peer
+	Path        string `json:"path"`   // if given, must be the peer's executable
+	Stream      string `json:"stream"` // "agent" (the default) or "logs"
+}
+
+// Listener accepts connections from the agents of apps that were not started
+// by the client, such as services started by an init system.
+type Listener struct {
+	l       *net.UnixListener
+	timeout time.Duration // for reading hello messages
+	apps    chan *Attached
+
+	mu     sync.Mutex
+	closed bool
+	conns  map[net.Conn]bool  // open connections
+	agents map[int]*Attached  // connected apps, by pid
+	logs   map[int]*logStream // logs of apps that have yet to connect
+}
+
+type logStream struct {
+	r    io.Reader
+	conn net.Conn
+}
+
+// socketMode is the mode of the socket of a Listener. Only the owner and group
+// of the client may connect.
+const socketMode = 0660
+
+// Listen listens on the Unix socket at path. A socket left at path by an
+// earlier listener is replaced. Connections that do not send their hello
+// message within timeout are dropped; zero means no limit.
+func Listen(path string, timeout time.Duration) (*Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	// The socket is created with socketMode, so that there is no moment at
+	// which others may connect. The umask is that of the whole process,
+	// so it is restored at once.
+	old := syscall.Umask(0777 &^ socketMode)
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	syscall.Umask(old)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		l:       ul,
+		timeout: timeout,
+		apps:    make(chan *Attached),
+		conns:   make(map[net.Conn]bool),
+		agents:  make(map[int]*Attached),
+		logs:    make(map[int]*logStream),
+	}
+	go l.serve()
+	return l, nil
+}
+
+var errClosed = errors.New("listener closed")
+
+// Accept returns the next app to connect. It fails once l is closed.
+func (l *Listener) Accept() (*Attached, error) {
+	a, ok := <-l.apps
+	if !ok {
+		return nil, errClosed
+	}
+	return a, nil
+}
+
+// Close stops l from accepting connections and disconnects the apps
+// connected to it.
+func (l *Listener) Close() error {
+	err := l.l.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	return err
+}
+
+func (l *Listener) serve() {
+	defer close(l.apps)
+	var wg sync.WaitGroup
+	for {
+		conn, err := l.l.AcceptUnix()
+		if err != nil {
+			break
+		}
+		if !l.track(conn) {
+			conn.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.handshake(conn)
+		}()
+	}
+	wg.Wait()
+}
+
+// track adds conn to the open connections. It reports false if l is closed.
+func (l *Listener) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	l.conns[conn] = true
+	return true
+}
+
+// drop closes conn.
+func (l *Listener) drop(conn net.Conn) {
+	l.mu.Lock()
+	delete(l.conns, conn)
+	l.mu.Unlock()
+	conn.Close()
+}
+
+// handshake reads the hello message from conn and sets up the stream it
+// announces.
+func (l *Listener) handshake(conn *net.UnixConn) {
+	a := &Attached{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	a.appLogs, a.logsW = io.Pipe()
+	a.agentData = readWriter{
+		Reader: disconnectReader{conn, a},
+		Writer: conn,
+	}
+
+	// The pid and path of the app are those of the process at the other
+	// end of the socket, not what it claims, so that it cannot pass as
+	// another app.
+	pid := peerPid(conn)
+	if pid == 0 {
+		log.Printf("attach: could not identify peer")
+		l.drop(conn)
+		return
+	}
+	var h hello
+	dec := json.NewDecoder(io.LimitReader(a.agentData, maxHello))
+	if l.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.timeout))
+	}
+	if err := dec.Decode(&h); err != nil {
+		log.Printf("attach: pid %v: could not read hello: %v", pid, err)
+		l.drop(conn)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if h.Pid != 0 && h.Pid != pid {
+		log.Printf("attach: pid %v: hello gives pid %v", pid, h.Pid)
+		l.drop(conn)
+		return
+	}
+	h.Pid = pid
+
+	switch h.Stream {
+	case "logs":
+		// The logs start after the newline that ends the hello.
+		buf, _ := ioutil.ReadAll(dec.Buffered())
+		l.addLogs(h.Pid, &logStream{
+			r:    io.MultiReader(bytes.NewReader(bytes.TrimPrefix(buf, []byte("\n"))), conn),
+			conn: conn,
+		})
+	case "", "agent":
+		if h.Version == "" {
+			log.Printf("attach: pid %v: %v", h.Pid, errNoVersion)
+			l.drop(conn)
+			return
+		}
+		path, err := peerPath(pid, h.Path)
+		if err != nil {
+			log.Printf("attach: pid %v: %v", h.Pid, err)
+			l.drop(conn)
+			return
+		}
+		agreed, err := agent.Negotiate(h.Hello)
+		if err == nil {
+			err = agreed.Reply(conn)
+		}
+		if err != nil {
+			log.Printf("attach: pid %v: %v", h.Pid, err)
+			l.drop(conn)
+			return
+		}
+		a.pid = h.Pid
+		a.path = path
+		a.agentVersion = h.Version
+		a.agreed = agreed
+		a.decoder = dec
+		a.listener = l
+		l.addAgent(a)
+		l.apps <- a
+	default:
+		log.Printf("attach: pid %v: unknown stream %q", h.Pid, h.Stream)
+		l.drop(conn)
+	}
+}
+
+// addAgent registers a, and gives it its logs if they have already
+// connected.
+func (l *Listener) addAgent(a *Attached) {
+	l.mu.Lock()
+	l.agents[a.pid] = a
+	s := l.logs[a.pid]
+	delete(l.logs, a.pid)
+	l.mu.Unlock()
+	if s != nil {
+		a.attachLogs(s)
+	}
+}
+
+// addLogs gives s to the app whose pid is pid, or keeps it until that app
+// connects.
+func (l *Listener) addLogs(pid int, s *logStream) {
+	l.mu.Lock()
+	a := l.agents[pid]
+	if a == nil {
+		if old := l.logs[pid]; old != nil {
+			delete(l.conns, old.conn)
+			old.conn.Close()
+		}
+		l.logs[pid] = s
+	}
+	l.mu.Unlock()
+	if a != nil {
+		a.attachLogs(s)
+	}
+}
+
+// removeAgent forgets a, which has disconnected, and its connections.
+func (l *Listener) removeAgent(a *Attached, conns ...net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.agents[a.pid] == a {
+		delete(l.agents, a.pid)
+	}
+	for _, conn := range conns {
+		delete(l.conns, conn)
+	}
+}
+
+// peerPath returns the path of the executable of the process whose pid is
+// pid. If claimed is not empty, it must name the same file.
+func peerPath(pid int, claimed string) (string, error) {
+	path, err := os.Readlink(fmt.Sprintf("/proc/%v/exe", pid))
+	if err != nil {
+		return "", err
+	}
+	if claimed == "" {
+		return path, nil
+	}
+	if resolved, err := filepath.EvalSymlinks(claimed); err != nil || resolved != path {
+		return "", fmt.Errorf("hello gives path %v, but the executable is %v", claimed, path)
+	}
+	return path, nil
+}
+
+// peerPid returns the pid of the process at the other end of conn, or 0 if it
+// cannot be determined.
+func peerPid(conn *net.UnixConn) int {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	pid := 0
+	raw.Control(func(fd uintptr) {
+		cred, err := syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+		if err == nil {
+			pid = int(cred.Pid)
+		}
+	})
+	return pid
+}
+
+// Attached is an app that connected to a Listener. Its exit status is not
+// known to the client: when its agent disconnects, it is taken to have exited
+// normally.
+type Attached struct {
+	pid          int
+	path         string
+	hash         string
+	agentVersion string
+	agreed       agent.Handshake
+
+	listener  *Listener
+	conn      net.Conn
+	agentData io.ReadWriter
+	decoder   *json.Decoder
+	appLogs   *io.PipeReader
+	logsW     *io.PipeWriter
+
+	mu       sync.Mutex
+	logsConn net.Conn      // the logs connection, if any
+	closed   sync.Once     // closes the connections
+	done     chan struct{} // closes when the agent disconnects
+}
+
+// disconnectReader reads from an agent connection, and disconnects the app
+// when reading fails. Every error reads as io.EOF, as it would from a pipe
+// whose writer had exited.
+type disconnectReader struct {
+	r io.Reader
+	a *Attached
+}
+
+func (d disconnectReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil {
+		d.a.Close()
+		err = io.EOF
+	}
+	return n, err
+}
+
+// attachLogs copies the app's logs from s.
+func (a *Attached) attachLogs(s *logStream) {
+	a.mu.Lock()
+	select {
+	case <-a.done:
+		a.mu.Unlock()
+		s.conn.Close()
+		return
+	default:
+	}
+	if a.logsConn != nil {
+		a.logsConn.Close()
+	}
+	a.logsConn = s.conn
+	a.mu.Unlock()
+	go func() {
+		_, err := io.Copy(a.logsW, s.r)
+		select {
+		case <-a.done:
+			// The connection was closed on disconnecting.
+		default:
+			if err != nil {
+				log.Printf("attach: pid %v: logs: %v", a.pid, err)
+			}
+		}
+	}()
+}
+
+// Close disconnects a.
+func (a *Attached) Close() error {
+	var err error
+	a.closed.Do(func() {
+		conns := []net.Conn{a.conn}
+		a.mu.Lock()
+		close(a.done)
+		if a.logsConn != nil {
+			a.logsConn.Close()
+			conns = append(conns, a.logsConn)
+		}
+		a.mu.Unlock()
+		a.logsW.Close()
+		err = a.conn.Close()
+		if a.listener != nil {
+			a.listener.removeAgent(a, conns...)
+		}
+	})
+	return err
+}
+
+// Pid returns the process ID of a.
+func (a *Attached) Pid() int { return a.pid }
+
+// Connect does nothing; a is connected when it is accepted.
+func (a *Attached) Connect() error { return nil }
+
+// Run waits for a to disconnect.
+func (a *Attached) Run() error {
+	<-a.done
+	return nil
+}
+
+var errNotChild = errors.New("attached apps are not signaled by the client")
+
+// SendSignal does not signal a, which belongs to another parent.
+func (a *Attached) SendSignal(os.Signal) error { return errNotChild }
+
+// ExitStatus returns 0.
+func (a *Attached) ExitStatus() int { return 0 }
+
+// Signal returns "".
+func (a *Attached) Signal() string { return "" }
+
+// ExitCode returns 0.
+func (a *Attached) ExitCode() int { return 0 }
+
+// CheckSum returns the SHA512/224 sum of a's executable file.
+func (a *Attached) CheckSum() string {
+	if a.hash == "" {
+		a.hash = fileSum(a.path)
+	}
+	return a.hash
+}
+
+// AgentVersion returns the agent version given by a.
+func (a *Attached) AgentVersion() string { return a.agentVersion }
+
+// Handshake returns what the client and the agent of a agreed to use.
+func (a *Attached) Handshake() agent.Handshake { return a.agreed }
+
+// AgentData returns a raw data stream from the agent.
+func (a *Attached) AgentData() io.ReadWriter { return a.agentData }
+
+// Decoder returns the JSON decoder that read the agent's hello from AgentData;
+// the data it buffered precedes the rest of AgentData.
+func (a *Attached) Decoder() *json.Decoder { return a.decoder }
+
+// AppLogs returns a's log stream, which is empty unless a connects its logs.
+func (a *Attached) AppLogs() io.Reader { return a.appLogs }
+
+// String returns the executable path, pid and agent version of a.
+func (a *Attached) String() string {
+	return fmt.Sprintf("%s[%d] %s", a.path, a.pid, a.agentVersion)
+}
@@ -0,0 +1,151 @@
+// +build linux
+
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auklet.sock")
+	// The socket mode does not depend on the umask.
+	umask := syscall.Umask(0)
+	l, err := Listen(path, 100*time.Millisecond)
+	syscall.Umask(umask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if fi, err := os.Stat(path); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != socketMode {
+		t.Errorf("expected socket mode %v, got %v", os.FileMode(socketMode), fi.Mode().Perm())
+	}
+
+	// Connections that claim to be another process are dropped.
+	var spoofs []net.Conn
+	for _, h := range []string{
+		`{"version":"1.2.3","pid":1}`,
+		`{"version":"1.2.3","path":"/bin/sh"}`,
+		`{"stream":"logs","pid":1}`,
+	} {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, h+"\n")
+		spoofs = append(spoofs, conn)
+	}
+
+	// The logs may connect before the agent.
+	logs, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	fmt.Fprintf(logs, `{"stream":"logs"}`+"\nhello from the app\n")
+
+	// A connection that does not give a version is dropped.
+	bad, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	fmt.Fprintf(bad, `{"pid":1}`+"\n")
+
+	// So is an agent whose version is not supported.
+	old, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	fmt.Fprintf(old, `{"version":"99.0.0"}`+"\n")
+
+	// So is a connection that sends nothing.
+	silent, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	agent, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(agent, `{"version":"1.2.3"}`+"\n"+`{"type":"profile","data":{}}`+"\n")
+
+	a, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Pid() != os.Getpid() {
+		t.Errorf("expected pid %v, got %v", os.Getpid(), a.Pid())
+	}
+	if a.AgentVersion() != "1.2.3" {
+		t.Errorf("expected agent version 1.2.3, got %v", a.AgentVersion())
+	}
+	if a.CheckSum() == "" {
+		t.Error("expected checksum of the test executable")
+	}
+
+	var msg struct{ Type string }
+	if err := a.Decoder().Decode(&msg); err != nil || msg.Type != "profile" {
+		t.Errorf("expected profile, got %v: %v", msg.Type, err)
+	}
+	line := bufio.NewScanner(a.AppLogs())
+	if !line.Scan() || line.Text() != "hello from the app" {
+		t.Errorf("expected log line, got %q: %v", line.Text(), line.Err())
+	}
+
+	old.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := old.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected incompatible agent to be dropped, got %v", err)
+	}
+
+	for i, conn := range spoofs {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("spoof %v: expected connection to be dropped, got %v", i, err)
+		}
+	}
+
+	silent.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := silent.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected silent connection to be dropped, got %v", err)
+	}
+
+	// Disconnecting the agent ends both streams.
+	agent.Close()
+	if err := a.Decoder().Decode(&msg); err == nil {
+		t.Error("expected EOF from agent data")
+	}
+	if line.Scan() {
+		t.Errorf("expected end of logs, got %q", line.Text())
+	}
+	if err := a.Run(); err != nil {
+		t.Error(err)
+	}
+
+	l.Close()
+	if _, err := l.Accept(); err == nil {
+		t.Error("expected error accepting from closed listener")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed, got %v", err)
+	}
+}
@@ -11,7 +11,12 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
+	"time"
+
+	"github.com/aukletio/Auklet-Client-C/agent"
+	"github.com/aukletio/Auklet-Client-C/device"
 )
 
 // Exec represents an executable.
@@ -20,12 +25,31 @@ type Exec struct {
 	cmd  *exec.Cmd
 
 	// state initialized after confirming that the application is released
-	appLogs   io.Reader
-	agentData io.ReadWriter // raw data stream from the agent
+	appLogs     io.Reader
+	agentData   io.ReadWriter // raw data stream from the agent
+	agentSocket deadliner     // the socket underlying agentData, if any
 
 	// state initialized after the process starts
 	agentVersion string
-	decoder      *json.Decoder // reading from agentData
+	agreed       agent.Handshake // what the client and agent use
+	decoder      *json.Decoder   // reading from agentData
+
+	// captured standard output and error, if captureOutput
+	captureOutput  bool
+	stdout, stderr io.Reader
+	pipes          []*os.File // write ends of the capture pipes
+	readEnds       []*os.File // closed once read to the end
+
+	recorder *Recorder // records the streams, if not nil
+	exited   sync.Once // records the exit
+
+	mu      sync.Mutex
+	started bool           // the process has been started
+	pending []os.Signal    // signals sent before the process started
+	rusage  *device.Rusage // set when the process exits
+
+	// how long to wait for the agent version; no limit if zero
+	handshakeTimeout time.Duration
 }
 
 // NewExec creates a new executable from one or more arguments.
@@ -40,6 +64,15 @@ func NewExec(name string, args ...string) (*Exec, error) {
 	}, nil
 }
 
+// AddEnv adds env, a list of "KEY=value" pairs, to the environment the
+// executable inherits from the client. It must be called before Start.
+func (exec *Exec) AddEnv(env ...string) {
+	if exec.cmd.Env == nil {
+		exec.cmd.Env = os.Environ()
+	}
+	exec.cmd.Env = append(exec.cmd.Env, env...)
+}
+
 var socketPair = socketpair
 
 // addSockets adds sockets to the executable so that we can communicate with
@@ -68,7 +101,83 @@ func (exec *Exec) addSockets() error {
 
 	exec.appLogs = appLogs.local
 	exec.agentData = agentData.local
+	exec.agentSocket = agentData.local
+
+	return nil
+}
+
+// CaptureOutput causes the standard output and error of exec to be read
+// through pipes, so that they can be read from Stdout and Stderr while still
+// reaching the client's own. It must be called before Connect.
+func (exec *Exec) CaptureOutput() { exec.captureOutput = true }
+
+// capture replaces the standard output and error of exec with pipes, if
+// CaptureOutput was called.
+func (exec *Exec) capture() error {
+	if !exec.captureOutput {
+		return nil
+	}
+	var err error
+	if exec.stdout, err = exec.tee(&exec.cmd.Stdout); err != nil {
+		return err
+	}
+	exec.stderr, err = exec.tee(&exec.cmd.Stderr)
+	return err
+}
+
+// tee replaces *w with the write end of a pipe, and returns a reader of the
+// pipe that copies what it reads to the original *w.
+func (exec *Exec) tee(w *io.Writer) (io.Reader, error) {
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	dst := *w
+	*w = pw
+	exec.pipes = append(exec.pipes, pw)
+	exec.readEnds = append(exec.readEnds, r)
+	return io.TeeReader(pipeReader{r}, dst), nil
+}
+
+// pipeReader reads from the read end of a capture pipe, and closes it when
+// reading fails. Every error reads as io.EOF.
+type pipeReader struct {
+	f *os.File
+}
+
+func (p pipeReader) Read(b []byte) (int, error) {
+	n, err := p.f.Read(b)
+	if err != nil {
+		p.f.Close()
+		err = io.EOF
+	}
+	return n, err
+}
+
+// outputGrace is how long the captured output of a process is read after it
+// exits. The pipes stay open while processes it started still hold them;
+// after this long, they are closed anyway.
+const outputGrace = time.Second
 
+// Record causes the streams read from exec, and its start and exit, to be
+// recorded by r. It must be called before Connect.
+func (exec *Exec) Record(r *Recorder) { exec.recorder = r }
+
+// record wraps the streams of exec so that they are recorded.
+func (exec *Exec) record() error {
+	if exec.recorder == nil {
+		return nil
+	}
+	exec.recorder.write(Record{
+		Stream:   StartStream,
+		Path:     exec.cmd.Path,
+		CheckSum: exec.CheckSum(),
+	})
+	exec.appLogs = exec.recorder.Tee(LogStream, exec.appLogs)
+	exec.agentData = readWriter{
+		Reader: exec.recorder.Tee(AgentStream, exec.agentData),
+		Writer: exec.agentData,
+	}
 	return nil
 }
 
@@ -80,7 +189,32 @@ func (exec *Exec) Start() error {
 	for _, file := range exec.cmd.ExtraFiles {
 		defer file.Close()
 	}
-	return exec.cmd.Start()
+	for _, file := range exec.pipes {
+		defer file.Close()
+	}
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if err := exec.cmd.Start(); err != nil {
+		return err
+	}
+	exec.started = true
+	for _, sig := range exec.pending {
+		exec.cmd.Process.Signal(sig)
+	}
+	exec.pending = nil
+	return nil
+}
+
+// SendSignal delivers sig to the process. If the process has not started, sig
+// is delivered as soon as it does.
+func (exec *Exec) SendSignal(sig os.Signal) error {
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if !exec.started {
+		exec.pending = append(exec.pending, sig)
+		return nil
+	}
+	return exec.cmd.Process.Signal(sig)
 }
 
 var (
@@ -89,63 +223,208 @@ var (
 	errNoVersion = errors.New("empty agent version")
 )
 
+// maxHello is the size in bytes of the largest hello message accepted from an
+// agent.
+const maxHello = 64 << 10
+
+// HandshakeError reports that an app was started, but its agent could not be
+// reached. The app keeps running, unmonitored.
+type HandshakeError struct {
+	Err error
+}
+
+func (e HandshakeError) Error() string {
+	return fmt.Sprintf("agent handshake failed: %v; app is running unmonitored", e.Err)
+}
+
+// SetHandshakeTimeout sets how long Connect waits for the agent to send its
+// version; zero, the default, means no limit.
+func (exec *Exec) SetHandshakeTimeout(d time.Duration) { exec.handshakeTimeout = d }
+
+// deadliner is a stream whose reads can be interrupted.
+type deadliner interface {
+	SetReadDeadline(time.Time) error
+}
+
+// helloResult is the outcome of reading an agent's hello message.
+type helloResult struct {
+	hello agent.Hello
+	dec   *json.Decoder
+	err   error
+}
+
 // getAgentVersion reads from the agentData stream and reads the agentVersion.
 // This function must be called after starting the executable.
 //
 // WARNING: Do not call this function on an unreleased executable!
 func (exec *Exec) getAgentVersion() error {
-	var msg struct {
-		Version string `json:"version"`
+	done := make(chan helloResult, 1)
+	go func() {
+		hello, dec, err := readHello(exec.agentData)
+		done <- helloResult{hello, dec, err}
+	}()
+
+	var timeout <-chan time.Time
+	if exec.handshakeTimeout > 0 {
+		t := time.NewTimer(exec.handshakeTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var r helloResult
+	select {
+	case r = <-done:
+	case <-timeout:
+		exec.stopReading(done)
+		return fmt.Errorf("no agent version within %v", exec.handshakeTimeout)
 	}
+	if r.err != nil {
+		return r.err
+	}
+	g, err := greet(exec.agentData, r.hello, r.dec)
+	if err != nil {
+		return err
+	}
+	exec.agentVersion = g.version
+	exec.agreed = g.agreed
+	exec.decoder = g.dec
+	return nil
+}
+
+// stopReading interrupts the reading of the agent's hello message, and waits
+// for it to finish, so that agentData is not read by two goroutines at once
+// once it is discarded.
+func (exec *Exec) stopReading(done <-chan helloResult) {
+	if exec.agentSocket == nil || exec.agentSocket.SetReadDeadline(time.Now()) != nil {
+		// The read cannot be interrupted; it finishes when the
+		// process closes agentData.
+		return
+	}
+	<-done
+	exec.agentSocket.SetReadDeadline(time.Time{})
+}
+
+// discard reads and discards the streams of exec until the process closes
+// them, so that it never blocks writing to them. Captured output still
+// reaches the client's own.
+func (exec *Exec) discard() {
+	for _, r := range []io.Reader{exec.agentData, exec.appLogs, exec.stdout, exec.stderr} {
+		if r != nil {
+			go io.Copy(ioutil.Discard, r)
+		}
+	}
+}
+
+// greeting is the outcome of a successful handshake with an agent.
+type greeting struct {
+	version string          // as given by the agent
+	agreed  agent.Handshake // what the client and agent use
+	dec     *json.Decoder   // reading the messages that follow the hello
+}
+
+// handshake reads the agent's hello message from agentData, checks that the
+// client can work with the agent, and replies if the agent expects it. The
+// decoder used is returned, since it may have buffered data that follows the
+// message.
+func handshake(agentData io.ReadWriter) (greeting, error) {
+	hello, dec, err := readHello(agentData)
+	if err != nil {
+		return greeting{}, err
+	}
+	return greet(agentData, hello, dec)
+}
 
-	// We should use a timeout here to avoid blocking indefinitely.
+// readHello reads the agent's hello message from agentData, with the decoder
+// it returns.
+func readHello(agentData io.Reader) (agent.Hello, *json.Decoder, error) {
+	var hello agent.Hello
 
-	dec := json.NewDecoder(exec.agentData)
-	if err := dec.Decode(&msg); err == io.EOF {
+	dec := json.NewDecoder(io.LimitReader(agentData, maxHello))
+	if err := dec.Decode(&hello); err == io.EOF {
 		// The process died before it could convey its agentVersion.
-		return errEOF
+		return hello, nil, errEOF
 	} else if err != nil {
 		// The process failed to speak versionMsg.
-		return errEncoding
+		return hello, nil, errEncoding
 	}
+	return hello, dec, nil
+}
 
-	if msg.Version == "" {
-		return errNoVersion
+// greet checks that the client can work with the agent that sent hello, and
+// replies on agentData if the agent expects it.
+func greet(agentData io.Writer, hello agent.Hello, dec *json.Decoder) (greeting, error) {
+	if hello.Version == "" {
+		return greeting{}, errNoVersion
 	}
-
-	exec.agentVersion = msg.Version
-	exec.decoder = dec
-
-	return nil
+	agreed, err := agent.Negotiate(hello)
+	if err != nil {
+		return greeting{}, err
+	}
+	if err := agreed.Reply(agentData); err != nil {
+		return greeting{}, err
+	}
+	return greeting{version: hello.Version, agreed: agreed, dec: dec}, nil
 }
 
 // Wait waits for the process to exit.
-func (exec *Exec) Wait() { exec.cmd.Wait() }
+func (exec *Exec) Wait() {
+	exec.cmd.Wait()
+	if exec.cmd.ProcessState == nil {
+		return
+	}
+	exec.exited.Do(func() {
+		exec.setRusage()
+		for _, r := range exec.readEnds {
+			r.SetReadDeadline(time.Now().Add(outputGrace))
+		}
+		if exec.recorder == nil {
+			return
+		}
+		ws := exec.cmd.ProcessState.Sys().(syscall.WaitStatus)
+		exec.recorder.write(Record{
+			Stream:     ExitStream,
+			ExitStatus: ws.ExitStatus(),
+			Signal:     signalName(ws),
+			ExitCode:   exitCode(ws),
+		})
+	})
+}
 
 // CheckSum returns the executable file's SHA512/224 sum.
 func (exec *Exec) CheckSum() string {
 	if exec.hash == "" {
-		bytes, err := ioutil.ReadFile(exec.cmd.Path)
-		if err != nil {
-			return ""
-		}
-
-		exec.hash = fmt.Sprintf("%x", sha512.Sum512_224(bytes))
+		exec.hash = fileSum(exec.cmd.Path)
 	}
 	return exec.hash
 }
 
-// ExitStatus returns the process's exit status.
-func (exec *Exec) ExitStatus() int {
+// fileSum returns the SHA512/224 sum of the file at path, or "" if it cannot
+// be read.
+func fileSum(path string) string {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha512.Sum512_224(bytes))
+}
+
+// status waits for the process to exit and returns its wait status.
+func (exec *Exec) status() syscall.WaitStatus {
 	exec.Wait()
-	return exec.cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
+	return exec.cmd.ProcessState.Sys().(syscall.WaitStatus)
 }
 
+// ExitStatus returns the process's exit status.
+func (exec *Exec) ExitStatus() int { return exec.status().ExitStatus() }
+
 // Signal returns the text description of the signal that killed the process, if
 // any.
-func (exec *Exec) Signal() string {
-	exec.Wait()
-	ws := exec.cmd.ProcessState.Sys().(syscall.WaitStatus)
+func (exec *Exec) Signal() string { return signalName(exec.status()) }
+
+// ExitCode returns the code a shell would report for the process: its exit
+// status, or 128 plus the signal number if it was killed by a signal.
+func (exec *Exec) ExitCode() int { return exitCode(exec.status()) }
+
+func signalName(ws syscall.WaitStatus) string {
 	sig := ""
 	if ws.Signaled() {
 		sig = ws.Signal().String()
@@ -153,17 +432,68 @@ func (exec *Exec) Signal() string {
 	return sig
 }
 
+func exitCode(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
 // String returns the exectuable path and agent version as a formatted string.
 func (exec *Exec) String() string {
 	return fmt.Sprintf("%s %s", exec.cmd.Path, exec.agentVersion)
 }
 
+// Pid returns the process ID, or 0 if the process has not started.
+func (exec *Exec) Pid() int {
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if !exec.started {
+		return 0
+	}
+	return exec.cmd.Process.Pid
+}
+
+// Rusage returns the resources used by the process, or nil if it has not
+// exited.
+func (exec *Exec) Rusage() *device.Rusage {
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	return exec.rusage
+}
+
+// setRusage records the resources used by the process, which has exited.
+func (exec *Exec) setRusage() {
+	ru, ok := exec.cmd.ProcessState.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return
+	}
+	ms := func(tv syscall.Timeval) int64 { return tv.Nano() / int64(time.Millisecond) }
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	exec.rusage = &device.Rusage{
+		UserTime:            ms(ru.Utime),
+		SystemTime:          ms(ru.Stime),
+		MaxRSS:              uint64(ru.Maxrss) << 10, // given in KiB
+		MinorFaults:         uint64(ru.Minflt),
+		MajorFaults:         uint64(ru.Majflt),
+		InBlocks:            uint64(ru.Inblock),
+		OutBlocks:           uint64(ru.Oublock),
+		VoluntarySwitches:   uint64(ru.Nvcsw),
+		InvoluntarySwitches: uint64(ru.Nivcsw),
+	}
+}
+
 // AgentVersion returns the agent version running in the process. It may be
 // called only after getAgentVersion succeeds.
 func (exec *Exec) AgentVersion() string {
 	return exec.agentVersion
 }
 
+// Handshake returns what the client and the agent agreed to use. It may be
+// called only after getAgentVersion succeeds.
+func (exec *Exec) Handshake() agent.Handshake { return exec.agreed }
+
 // Run runs exec and waits for it to stop.
 func (exec *Exec) Run() error {
 	if err := exec.Start(); err != nil {
@@ -173,25 +503,41 @@ func (exec *Exec) Run() error {
 	return nil
 }
 
-// Connect adds sockets, starts, and gets the agent version of exec.
+// Connect adds sockets, starts, and gets the agent version of exec. If the
+// process starts but the agent version cannot be read, Connect returns a
+// HandshakeError, and the streams of exec are discarded.
 func (exec *Exec) Connect() error {
 	for _, fn := range []func() error{
 		exec.addSockets,
+		exec.capture,
+		exec.record,
 		exec.Start,
-		exec.getAgentVersion,
 	} {
 		if err := fn(); err != nil {
 			return err
 		}
 	}
+	if err := exec.getAgentVersion(); err != nil {
+		exec.discard()
+		return HandshakeError{err}
+	}
 	return nil
 }
 
 // AgentData returns a raw data stream from the agent.
 func (exec *Exec) AgentData() io.ReadWriter { return exec.agentData }
 
-// Decoder returns a JSON decoder reading from AgentData.
+// Decoder returns the JSON decoder that read the agent's hello from AgentData;
+// the data it buffered precedes the rest of AgentData.
 func (exec *Exec) Decoder() *json.Decoder { return exec.decoder }
 
 // AppLogs returns a raw stream of application log data from the child process.
 func (exec *Exec) AppLogs() io.Reader { return exec.appLogs }
+
+// Stdout returns the standard output of the process, or nil if it is not
+// captured.
+func (exec *Exec) Stdout() io.Reader { return exec.stdout }
+
+// Stderr returns the standard error of the process, or nil if it is not
+// captured.
+func (exec *Exec) Stderr() io.Reader { return exec.stderr }
@@ -3,7 +3,16 @@ package app
 import (
 	"bytes"
 	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/aukletio/Auklet-Client-C/agent"
 )
 
 func TestMethods(t *testing.T) {
@@ -63,6 +72,19 @@ func TestExec(t *testing.T) {
 	}
 }
 
+func TestSignalBeforeStart(t *testing.T) {
+	exec := must(NewExec("sleep", "10"))
+	if err := exec.SendSignal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if sig := exec.Signal(); sig != syscall.SIGTERM.String() {
+		t.Errorf("expected %q, got %q", syscall.SIGTERM.String(), sig)
+	}
+}
+
 var errSocketPair = errors.New("socketpair failed")
 
 func must(exec *Exec, err error) *Exec {
@@ -141,6 +163,58 @@ func TestGetAgentVersion(t *testing.T) {
 			t.Errorf(format, i, c.expect, err)
 		}
 	}
+
+	// agents the client cannot work with
+	for _, hello := range []string{
+		`{"version":"99.0.0"}`,
+		`{"version":"1.0.0","capabilities":{"encodings":["xml"]}}`,
+	} {
+		e := &Exec{agentData: bytes.NewBufferString(hello)}
+		if _, ok := e.getAgentVersion().(agent.IncompatibleError); !ok {
+			t.Errorf("%v: expected incompatible agent", hello)
+		}
+	}
+
+	// an agent that advertises its capabilities gets a reply
+	buf := bytes.NewBufferString(`{"version":"1.2.0","capabilities":{"messages":["event"],"encodings":["json"]}}`)
+	e := &Exec{agentData: buf}
+	if err := e.getAgentVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if e.Handshake().Uses("emit") {
+		t.Error("expected emit not to be used")
+	}
+	if !strings.Contains(buf.String(), `"encoding":"json"`) {
+		t.Errorf("expected reply, got %q", buf.String())
+	}
+
+	// an agent that never sends its version
+	r, _ := io.Pipe()
+	e = &Exec{agentData: readWriter{Reader: r}, handshakeTimeout: 10 * time.Millisecond}
+	if err := e.getAgentVersion(); err == nil {
+		t.Error("expected timeout")
+	}
+}
+
+func TestLateHello(t *testing.T) {
+	client, agent := net.Pipe()
+	defer agent.Close()
+	e := &Exec{agentData: client, agentSocket: client, handshakeTimeout: 10 * time.Millisecond}
+	if err := e.getAgentVersion(); err == nil {
+		t.Fatal("expected timeout")
+	}
+
+	// The hello that arrives after the timeout is discarded, and not
+	// replied to.
+	e.discard()
+	agent.SetDeadline(time.Now().Add(time.Second))
+	if _, err := agent.Write([]byte(`{"version":"1.2.0","capabilities":{"encodings":["json"]}}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	agent.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := agent.Read(make([]byte, 1)); err == nil {
+		t.Errorf("expected no reply, got %v bytes", n)
+	}
 }
 
 func TestConnect(t *testing.T) {
@@ -157,13 +231,86 @@ func TestConnect(t *testing.T) {
 	}
 }
 
+func TestConnectTimeout(t *testing.T) {
+	// sleep has no agent, so it never sends its version.
+	e := must(NewExec("/bin/sleep", "0.2"))
+	e.SetHandshakeTimeout(10 * time.Millisecond)
+	err := e.Connect()
+	if _, ok := err.(HandshakeError); !ok {
+		t.Fatalf("expected HandshakeError, got %v", err)
+	}
+	// The app keeps running.
+	if got := e.ExitStatus(); got != 0 {
+		t.Errorf("expected exit status 0, got %v", got)
+	}
+}
+
+func TestCaptureOutput(t *testing.T) {
+	e := must(NewExec("/bin/sh", "-c", `echo '{"version":"1.0.0"}' >&4; echo out; echo err >&2`))
+	var stdout, stderr bytes.Buffer
+	e.cmd.Stdout, e.cmd.Stderr = &stdout, &stderr
+	e.CaptureOutput()
+	if err := e.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		captured io.Reader
+		passed   *bytes.Buffer
+		expect   string
+	}{
+		{e.Stdout(), &stdout, "out\n"},
+		{e.Stderr(), &stderr, "err\n"},
+	} {
+		got, err := ioutil.ReadAll(c.captured)
+		if err != nil || string(got) != c.expect || c.passed.String() != c.expect {
+			t.Errorf("expected %q, got %q and %q: %v", c.expect, got, c.passed, err)
+		}
+	}
+	e.Wait()
+
+	// The pipes are closed once read to the end.
+	for i, r := range e.readEnds {
+		_, err := r.Read(make([]byte, 1))
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != os.ErrClosed {
+			t.Errorf("pipe %v: expected it to be closed, got %v", i, err)
+		}
+	}
+}
+
 func TestRun(t *testing.T) {
 	e := must(NewExec("testdata/noexec"))
 	if err := e.Run(); err == nil {
 		t.Fail()
 	}
 	e = must(NewExec("testdata/ls"))
+	if e.Rusage() != nil {
+		t.Error("expected no rusage before the process exits")
+	}
 	if err := e.Run(); err != nil {
 		t.Error(err)
 	}
+	if e.Rusage() == nil {
+		t.Error("expected rusage after the process exits")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		path   string
+		expect int
+	}{
+		{path: "/bin/true", expect: 0},
+		{path: "testdata/fail", expect: 3},
+		{path: "testdata/ls", expect: 128 + 15}, // killed by SIGTERM
+	}
+
+	for i, c := range cases {
+		e := must(NewExec(c.path))
+		if err := e.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if got := e.ExitCode(); got != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
+		}
+	}
 }
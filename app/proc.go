@@ -0,0 +1,87 @@
+// +build linux
+
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProcState is the state of a process, as read from /proc.
+type ProcState struct {
+	Pid     int           `json:"pid"`
+	State   string        `json:"state"` // such as "S (sleeping)"
+	Threads []ThreadState `json:"threads"`
+}
+
+// ThreadState is the state of one thread of a process.
+type ThreadState struct {
+	Tid   int    `json:"tid"`
+	Name  string `json:"name"`
+	State string `json:"state"`           // a letter, such as "S" or "D"
+	Wchan string `json:"wchan,omitempty"` // kernel function it waits in
+}
+
+// ReadProcState returns the state of the process whose pid is pid.
+func ReadProcState(pid int) (ProcState, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	p := ProcState{Pid: pid}
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return ProcState{}, err
+	}
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		if s := strings.TrimPrefix(lines.Text(), "State:"); s != lines.Text() {
+			p.State = strings.TrimSpace(s)
+			break
+		}
+	}
+
+	tasks, err := ioutil.ReadDir(filepath.Join(dir, "task"))
+	if err != nil {
+		return ProcState{}, err
+	}
+	for _, task := range tasks {
+		t, err := readThreadState(filepath.Join(dir, "task", task.Name()))
+		if err != nil {
+			// The thread may have exited since the directory was read.
+			continue
+		}
+		p.Threads = append(p.Threads, t)
+	}
+	return p, nil
+}
+
+// readThreadState reads the state of the thread whose /proc directory is dir.
+func readThreadState(dir string) (ThreadState, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return ThreadState{}, err
+	}
+	// The name is in parentheses, and may itself contain spaces and
+	// parentheses.
+	stat := string(b)
+	i, j := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if i < 0 || j < i {
+		return ThreadState{}, fmt.Errorf("malformed %v/stat", dir)
+	}
+	var t ThreadState
+	if t.Tid, err = strconv.Atoi(strings.TrimSpace(stat[:i])); err != nil {
+		return ThreadState{}, fmt.Errorf("malformed %v/stat", dir)
+	}
+	t.Name = stat[i+1 : j]
+	if fields := strings.Fields(stat[j+1:]); len(fields) > 0 {
+		t.State = fields[0]
+	}
+	if w, err := ioutil.ReadFile(filepath.Join(dir, "wchan")); err == nil && string(w) != "0" {
+		t.Wchan = string(w)
+	}
+	return t, nil
+}
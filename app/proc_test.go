@@ -0,0 +1,56 @@
+// +build linux
+
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadProcState(t *testing.T) {
+	p, err := ReadProcState(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.State == "" || len(p.Threads) == 0 {
+		t.Errorf("expected state and threads, got %+v", p)
+	}
+	if p.Threads[0].State == "" {
+		t.Errorf("expected thread state, got %+v", p.Threads[0])
+	}
+
+	if _, err := ReadProcState(-1); err == nil {
+		t.Error("expected error for missing process")
+	}
+}
+
+func TestReadThreadState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("stat", "42 (a (tricky) name) D 1 42 42 0 -1")
+	write("wchan", "futex_wait_queue")
+	th, err := readThreadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := ThreadState{Tid: 42, Name: "a (tricky) name", State: "D", Wchan: "futex_wait_queue"}
+	if th != expect {
+		t.Errorf("expected %+v, got %+v", expect, th)
+	}
+
+	write("stat", "garbage")
+	if _, err := readThreadState(dir); err == nil {
+		t.Error("expected error for malformed stat")
+	}
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Streams of a recording.
+const (
+	StartStream = "start" // the app was started
+	AgentStream = "agent" // data the agent sent on fd 4
+	LogStream   = "log"   // data the app wrote on fd 3
+	ExitStream  = "exit"  // the app exited
+)
+
+// Record is an entry in a recording. Recordings consist of one Record per
+// line, encoded as JSON.
+type Record struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+
+	// for AgentStream and LogStream
+	Data []byte `json:"data,omitempty"`
+
+	// for StartStream
+	Path     string `json:"path,omitempty"`
+	CheckSum string `json:"checksum,omitempty"`
+
+	// for ExitStream
+	ExitStatus int    `json:"exitStatus,omitempty"`
+	Signal     string `json:"signal,omitempty"`
+	ExitCode   int    `json:"exitCode,omitempty"`
+}
+
+// Recorder writes the streams read from apps to a recording.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// NewRecorder returns a Recorder that writes to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		enc: json.NewEncoder(w),
+		now: time.Now,
+	}
+}
+
+// write timestamps rec and appends it to the recording.
+func (r *Recorder) write(rec Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec.Time = r.now()
+	// A failure to record must not disturb the app being recorded.
+	r.enc.Encode(rec)
+}
+
+// Tee returns a Reader that records everything read from in as the named
+// stream.
+func (r *Recorder) Tee(stream string, in io.Reader) io.Reader {
+	return tee{in: in, stream: stream, r: r}
+}
+
+type tee struct {
+	in     io.Reader
+	stream string
+	r      *Recorder
+}
+
+func (t tee) Read(p []byte) (int, error) {
+	n, err := t.in.Read(p)
+	if n > 0 {
+		t.r.write(Record{
+			Stream: t.stream,
+			Data:   append([]byte(nil), p[:n]...),
+		})
+	}
+	return n, err
+}
+
+// Recording is a recorded run of an app.
+type Recording struct {
+	Path     string
+	CheckSum string
+
+	// Exited reports whether the recording includes the app's exit. If it
+	// does not, the remaining fields are zero.
+	Exited     bool
+	ExitStatus int
+	Signal     string
+	ExitCode   int
+
+	// Records holds the agent and log records of the run, in order.
+	Records []Record
+}
+
+// ReadRecordings reads the runs recorded in r, in order.
+func ReadRecordings(r io.Reader) ([]Recording, error) {
+	var runs []Recording
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var rec Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("recording: record %v: %v", line, err)
+		}
+		if rec.Stream == StartStream {
+			runs = append(runs, Recording{
+				Path:     rec.Path,
+				CheckSum: rec.CheckSum,
+			})
+			continue
+		}
+		if len(runs) == 0 {
+			return nil, fmt.Errorf("recording: record %v: %v before %v", line, rec.Stream, StartStream)
+		}
+		run := &runs[len(runs)-1]
+		switch rec.Stream {
+		case AgentStream, LogStream:
+			run.Records = append(run.Records, rec)
+		case ExitStream:
+			run.Exited = true
+			run.ExitStatus = rec.ExitStatus
+			run.Signal = rec.Signal
+			run.ExitCode = rec.ExitCode
+		default:
+			return nil, fmt.Errorf("recording: record %v: unknown stream %q", line, rec.Stream)
+		}
+	}
+	return runs, nil
+}
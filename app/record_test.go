@@ -0,0 +1,73 @@
+package app
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+	r.now = func() time.Time { return time.Unix(0, 0) }
+
+	r.write(Record{Stream: StartStream, Path: "app", CheckSum: "sum"})
+	agent := `{"version":"1.0"}{"type":"profile","data":{}}`
+	logs := "hello\nworld\n"
+	for stream, data := range map[string]string{AgentStream: agent, LogStream: logs} {
+		if _, err := ioutil.ReadAll(r.Tee(stream, strings.NewReader(data))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.write(Record{Stream: ExitStream, ExitStatus: 3, ExitCode: 3})
+
+	runs, err := ReadRecordings(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("expected 1 run, got %v", len(runs))
+	}
+
+	replay := NewReplay(runs[0], false)
+	if err := replay.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	var msg struct{ Type string }
+	if err := replay.Decoder().Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	gotLogs, _ := ioutil.ReadAll(replay.AppLogs())
+
+	cases := []struct {
+		got, expect interface{}
+	}{
+		{replay.CheckSum(), "sum"},
+		{replay.AgentVersion(), "1.0"},
+		{msg.Type, "profile"},
+		{string(gotLogs), logs},
+		{replay.ExitStatus(), 3},
+		{replay.Signal(), ""},
+		{replay.ExitCode(), 3},
+	}
+	for i, c := range cases {
+		if c.got != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, c.got)
+		}
+	}
+}
+
+func TestReadRecordingsErrors(t *testing.T) {
+	cases := []string{
+		`{"stream":"agent"}`,
+		`{"stream":"start"}{"stream":"bogus"}`,
+		`{"stream":`,
+	}
+	for i, c := range cases {
+		if _, err := ReadRecordings(strings.NewReader(c)); err == nil {
+			t.Errorf("case %v: expected error, got nil", i)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aukletio/Auklet-Client-C/agent"
+)
+
+// Replay is an app whose streams are played back from a Recording instead of
+// being read from a running process.
+type Replay struct {
+	rec      Recording
+	realtime bool // whether to keep the recorded pace
+
+	agentData    io.ReadWriter
+	appLogs      io.Reader
+	agentVersion string
+	agreed       agent.Handshake
+	decoder      *json.Decoder
+	done         chan struct{} // closes when playback has finished
+}
+
+// NewReplay returns a Replay of rec. If realtime is true, the records are
+// played back at the pace at which they were recorded; otherwise, as fast as
+// they are consumed.
+func NewReplay(rec Recording, realtime bool) *Replay {
+	return &Replay{
+		rec:      rec,
+		realtime: realtime,
+		done:     make(chan struct{}),
+	}
+}
+
+// readWriter combines a Reader and a Writer.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+// Connect starts playback and reads the agent version from it.
+func (r *Replay) Connect() error {
+	agentR, agentW := io.Pipe()
+	logsR, logsW := io.Pipe()
+	// Emission requests have nobody to go to.
+	r.agentData = readWriter{agentR, ioutil.Discard}
+	r.appLogs = logsR
+
+	// The streams are independent, as they are for a process, so that
+	// neither blocks the other while its reader is not ready.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go r.play(AgentStream, agentW, &wg)
+	go r.play(LogStream, logsW, &wg)
+	go func() {
+		wg.Wait()
+		close(r.done)
+	}()
+
+	g, err := handshake(r.agentData)
+	if err != nil {
+		return err
+	}
+	r.agentVersion = g.version
+	r.agreed = g.agreed
+	r.decoder = g.dec
+	return nil
+}
+
+// play writes the data of the named stream to w, then closes w.
+func (r *Replay) play(stream string, w *io.PipeWriter, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer w.Close()
+	var start time.Time
+	if len(r.rec.Records) > 0 {
+		start = r.rec.Records[0].Time
+	}
+	began := time.Now()
+	for _, rec := range r.rec.Records {
+		if rec.Stream != stream {
+			continue
+		}
+		if r.realtime {
+			time.Sleep(rec.Time.Sub(start) - time.Since(began))
+		}
+		if _, err := w.Write(rec.Data); err != nil {
+			return
+		}
+	}
+}
+
+// Run plays back nothing, since an app that is merely run is not connected to.
+func (r *Replay) Run() error { return nil }
+
+// SendSignal does nothing, since there is no process.
+func (r *Replay) SendSignal(os.Signal) error { return nil }
+
+// wait waits for playback to finish, if it has started.
+func (r *Replay) wait() {
+	if r.agentData != nil {
+		<-r.done
+	}
+}
+
+// ExitStatus returns the recorded exit status.
+func (r *Replay) ExitStatus() int { r.wait(); return r.rec.ExitStatus }
+
+// Signal returns the recorded signal.
+func (r *Replay) Signal() string { r.wait(); return r.rec.Signal }
+
+// ExitCode returns the recorded exit code.
+func (r *Replay) ExitCode() int { r.wait(); return r.rec.ExitCode }
+
+// CheckSum returns the recorded checksum.
+func (r *Replay) CheckSum() string { return r.rec.CheckSum }
+
+// AgentVersion returns the agent version read from the recording. It may be
+// called only after Connect succeeds.
+func (r *Replay) AgentVersion() string { return r.agentVersion }
+
+// Handshake returns what the client and the recorded agent agreed to use. It
+// may be called only after Connect succeeds.
+func (r *Replay) Handshake() agent.Handshake { return r.agreed }
+
+// AgentData returns the played-back agent stream.
+func (r *Replay) AgentData() io.ReadWriter { return r.agentData }
+
+// Decoder returns the JSON decoder that read the agent's hello from AgentData;
+// the data it buffered precedes the rest of AgentData.
+func (r *Replay) Decoder() *json.Decoder { return r.decoder }
+
+// AppLogs returns the played-back log stream.
+func (r *Replay) AppLogs() io.Reader { return r.appLogs }
+
+// String returns the recorded path as a formatted string.
+func (r *Replay) String() string { return "replay of " + r.rec.Path }
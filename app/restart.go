@@ -0,0 +1,53 @@
+package app
+
+import (
+	"math"
+	"time"
+)
+
+// RestartPolicy determines whether and when a supervised app is restarted
+// after it crashes.
+type RestartPolicy struct {
+	// Enabled causes crashed apps to be restarted.
+	Enabled bool
+
+	// MaxRestarts limits the number of restarts. If zero, the app is
+	// restarted indefinitely.
+	MaxRestarts int
+
+	// Backoff is the delay before the first restart. The delay doubles
+	// with each subsequent restart, but never exceeds MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Crashed reports whether an app that exited with the given status and
+// signal crashed.
+func Crashed(status int, signal string) bool {
+	return signal != "" || status != 0
+}
+
+// Restart reports whether an app that has already been restarted n times
+// should be restarted, given that it exited with status and signal.
+func (p RestartPolicy) Restart(n, status int, signal string) bool {
+	if !p.Enabled || !Crashed(status, signal) {
+		return false
+	}
+	return p.MaxRestarts == 0 || n < p.MaxRestarts
+}
+
+// Delay returns how long to wait before restarting an app that has already
+// been restarted n times.
+func (p RestartPolicy) Delay(n int) time.Duration {
+	d := p.Backoff
+	for i := 0; i < n; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff || d > math.MaxInt64/2 {
+			break
+		}
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
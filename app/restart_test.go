@@ -0,0 +1,104 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestart(t *testing.T) {
+	cases := []struct {
+		policy RestartPolicy
+		n      int
+		status int
+		signal string
+		expect bool
+	}{
+		{
+			// disabled
+			policy: RestartPolicy{},
+			status: 1,
+			expect: false,
+		},
+		{
+			// clean exit
+			policy: RestartPolicy{Enabled: true},
+			status: 0,
+			expect: false,
+		},
+		{
+			policy: RestartPolicy{Enabled: true},
+			status: 1,
+			expect: true,
+		},
+		{
+			policy: RestartPolicy{Enabled: true},
+			status: -1,
+			signal: "segmentation fault",
+			expect: true,
+		},
+		{
+			// unlimited
+			policy: RestartPolicy{Enabled: true},
+			n:      1000,
+			status: 1,
+			expect: true,
+		},
+		{
+			policy: RestartPolicy{Enabled: true, MaxRestarts: 3},
+			n:      2,
+			status: 1,
+			expect: true,
+		},
+		{
+			policy: RestartPolicy{Enabled: true, MaxRestarts: 3},
+			n:      3,
+			status: 1,
+			expect: false,
+		},
+	}
+
+	for i, c := range cases {
+		if got := c.policy.Restart(c.n, c.status, c.signal); got != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	cases := []struct {
+		policy RestartPolicy
+		n      int
+		expect time.Duration
+	}{
+		{
+			policy: RestartPolicy{},
+			expect: 0,
+		},
+		{
+			policy: RestartPolicy{Backoff: time.Second},
+			n:      0,
+			expect: time.Second,
+		},
+		{
+			policy: RestartPolicy{Backoff: time.Second},
+			n:      3,
+			expect: 8 * time.Second,
+		},
+		{
+			policy: RestartPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second},
+			n:      3,
+			expect: 5 * time.Second,
+		},
+		{
+			policy: RestartPolicy{Backoff: time.Second, MaxBackoff: time.Minute},
+			n:      1000,
+			expect: time.Minute,
+		},
+	}
+
+	for i, c := range cases {
+		if got := c.policy.Delay(c.n); got != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
+		}
+	}
+}
@@ -21,6 +21,11 @@ func socketpair(prefix string) (p pair, err error) {
 		return
 	}
 
+	// The local end is non-blocking, so that reads from it can be given
+	// deadlines. The remote end is left as the app expects it.
+	if err = syscall.SetNonblock(fd[0], true); err != nil {
+		return
+	}
 	p = pair{
 		local:  os.NewFile(uintptr(fd[0]), prefix+"-local"),
 		remote: os.NewFile(uintptr(fd[1]), prefix+"-remote"),
@@ -94,21 +94,43 @@ func NewMQTTProducer(cfg Config) (*MQTTProducer, error) {
 	}, nil
 }
 
-// Serve launches p, enabling it to send and receive messages.
+// quiesce is how many milliseconds the client may spend completing pending
+// work when disconnecting.
+const quiesce = 250
+
+// Serve launches p, enabling it to send and receive messages. It returns after
+// in closes and p has disconnected from the broker.
 func (p MQTTProducer) Serve(in MessageSource) {
-	defer func() {
-		p.c.Disconnect(0)
-		log.Print("producer: disconnected")
-	}()
+	p.Deliver(in)
+}
+
+// Close disconnects p from the broker.
+func (p MQTTProducer) Close() {
+	p.c.Disconnect(quiesce)
+	log.Print("producer: disconnected")
+}
+
+// Summary counts the outcomes of publishing a stream of messages.
+type Summary struct {
+	Sent   int // published, and removed from the persistence layer
+	Failed int // not published, and left in the persistence layer
+}
+
+// Deliver is like Serve, but returns a Summary of the messages it handled.
+func (p MQTTProducer) Deliver(in MessageSource) (s Summary) {
+	defer p.Close()
 
 	for msg := range in.Output() {
 		topic := fmt.Sprintf("c/%v/%v/%v", msg.Topic, p.org, p.id)
 		err := wait(p.c.Publish(topic, 1, false, msg.Bytes))
 		if err != nil {
 			errorlog.Print("publishing to broker:", err)
+			s.Failed++
 			continue
 		}
 		log.Printf("producer: sent %+q", msg.Bytes)
 		msg.Remove()
+		s.Sent++
 	}
+	return
 }
@@ -79,19 +79,30 @@ func TestPublish(t *testing.T) {
 	defer func() { wait = orig }()
 
 	errPublish := errors.New("publish error")
-	cases := []func(token) error{
-		func(token) error { return nil },
-		func(token) error { return errPublish },
+	cases := []struct {
+		wait   func(token) error
+		expect Summary
+	}{
+		{
+			wait:   func(token) error { return nil },
+			expect: Summary{Sent: 1},
+		},
+		{
+			wait:   func(token) error { return errPublish },
+			expect: Summary{Failed: 1},
+		},
 	}
 
-	for _, c := range cases {
-		wait = c
+	for i, c := range cases {
+		wait = c.wait
 		source := make(channel)
 		go func() {
 			defer close(source)
 			source <- Message{}
 		}()
-		MQTTProducer{c: klient{}}.Serve(source)
+		if got := (MQTTProducer{c: klient{}}).Deliver(source); got != c.expect {
+			t.Errorf("case %v: expected %+v, got %+v", i, c.expect, got)
+		}
 	}
 }
 
@@ -0,0 +1,171 @@
+package broker
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Queue provides access to the messages stored in a directory by a
+// Persistor.
+type Queue struct {
+	Dir string
+	Fs  Fs
+}
+
+// Entry describes a stored message.
+type Entry struct {
+	Message
+	ID      string    // the name of the message's file
+	Size    int64     // the size of the message's file in bytes
+	ModTime time.Time // when the message was stored
+}
+
+// ID returns the identifier of m in its Queue, or the empty string if m is not
+// stored.
+func (m Message) ID() string {
+	if m.path == "" {
+		return ""
+	}
+	return filepath.Base(m.path)
+}
+
+// List returns the stored messages, oldest first. Messages that could not be
+// read are included, with their Error field set.
+func (q Queue) List() ([]Entry, error) {
+	paths, err := filepaths(q.Dir, q.Fs)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(paths))
+	for _, path := range paths {
+		e, err := q.entry(path)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !a.ModTime.Equal(b.ModTime) {
+			return a.ModTime.Before(b.ModTime)
+		}
+		return idLess(a.ID, b.ID)
+	})
+	return entries, nil
+}
+
+// idLess reports whether the message ID a, of the form <pid>-<count>, sorts
+// before b. The numbers are compared numerically, so that "1-9" comes before
+// "1-10". IDs not of that form sort after those that are, by their text.
+func idLess(a, b string) bool {
+	pa, ca, okA := parseID(a)
+	pb, cb, okB := parseID(b)
+	switch {
+	case okA && okB:
+		if pa != pb {
+			return pa < pb
+		}
+		return ca < cb
+	case okA != okB:
+		return okA
+	}
+	return a < b
+}
+
+// parseID returns the pid and count of the message ID id.
+func parseID(id string) (pid, count uint64, ok bool) {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		return 0, 0, false
+	}
+	pid, err := strconv.ParseUint(id[:i], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	count, err = strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return pid, count, true
+}
+
+func (q Queue) entry(path string) (Entry, error) {
+	info, err := q.Fs.Stat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	m := loadMessage(path, q.Fs)
+	return Entry{
+		Message: m,
+		ID:      m.ID(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+// ErrNoMessage indicates that a Queue has no message with the given ID.
+type ErrNoMessage struct {
+	ID string
+}
+
+// Error returns e as a string.
+func (e ErrNoMessage) Error() string {
+	return fmt.Sprintf("queue: no message %q", e.ID)
+}
+
+// path returns the path of the message with the given ID.
+func (q Queue) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return "", ErrNoMessage{id}
+	}
+	path := q.Dir + "/" + id
+	if _, err := q.Fs.Stat(path); err != nil {
+		return "", ErrNoMessage{id}
+	}
+	return path, nil
+}
+
+// Get returns the stored message with the given ID.
+func (q Queue) Get(id string) (Entry, error) {
+	path, err := q.path(id)
+	if err != nil {
+		return Entry{}, err
+	}
+	return q.entry(path)
+}
+
+// Remove deletes the stored message with the given ID.
+func (q Queue) Remove(id string) error {
+	path, err := q.path(id)
+	if err != nil {
+		return err
+	}
+	return q.Fs.Remove(path)
+}
+
+// Stats summarizes the stored messages of one topic.
+type Stats struct {
+	Count int
+	Bytes int64
+}
+
+// Stats returns a summary of the stored messages, by topic. Messages that
+// could not be decoded have the empty topic.
+func (q Queue) Stats() (map[Topic]Stats, error) {
+	entries, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[Topic]Stats)
+	for _, e := range entries {
+		s := stats[e.Topic]
+		s.Count++
+		s.Bytes += e.Size
+		stats[e.Topic] = s
+	}
+	return stats, nil
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/fsutil"
+)
+
+func queue(files map[string]string) Queue {
+	fs := afero.NewMemMapFs()
+	if err := fs.Mkdir("dir", 0777); err != nil {
+		panic(err)
+	}
+	// Give the files the same modification time, so that they are
+	// listed in order of their IDs.
+	mtime := time.Now()
+	for name, contents := range files {
+		if err := fsutil.WriteFile(fs.OpenFile, "dir/"+name, []byte(contents)); err != nil {
+			panic(err)
+		}
+		if err := fs.Chtimes("dir/"+name, mtime, mtime); err != nil {
+			panic(err)
+		}
+	}
+	return Queue{Dir: "dir", Fs: fs}
+}
+
+func TestQueueList(t *testing.T) {
+	q := queue(map[string]string{
+		"1-0":  `{"topic":"events","bytes":"AA=="}`,
+		"1-1":  `{"topic":"profiler","bytes":"AA=="}`,
+		"1-2":  `garbage`,
+		"1-10": `{"topic":"events","bytes":"AA=="}`,
+		"2-9":  `{"topic":"events","bytes":"AA=="}`,
+	})
+	entries, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %v", len(entries))
+	}
+	for i, id := range []string{"1-0", "1-1", "1-2", "1-10", "2-9"} {
+		if entries[i].ID != id {
+			t.Errorf("entry %v: expected %v, got %v", i, id, entries[i].ID)
+		}
+	}
+	if entries[2].Error == "" {
+		t.Error("expected decoding error for garbage message")
+	}
+}
+
+func TestQueueGetRemove(t *testing.T) {
+	q := queue(map[string]string{"1-0": `{"topic":"events"}`})
+	cases := []struct {
+		id string
+		ok bool
+	}{
+		{id: "1-0", ok: true},
+		{id: "1-1", ok: false},
+		{id: "../dir/1-0", ok: false},
+		{id: "", ok: false},
+	}
+
+	for i, c := range cases {
+		_, err := q.Get(c.id)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: Get: expected %v, got %v: %v", i, c.ok, ok, err)
+		}
+	}
+
+	if err := q.Remove("1-0"); err != nil {
+		t.Error(err)
+	}
+	if err := q.Remove("1-0"); err == nil {
+		t.Error("expected error removing a message twice")
+	}
+}
+
+func TestQueueStats(t *testing.T) {
+	q := queue(map[string]string{
+		"1-0": `{"topic":"events"}`,
+		"1-1": `{"topic":"events"}`,
+		"1-2": `{"topic":"profiler"}`,
+	})
+	stats, err := q.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := stats[Event]; s.Count != 2 || s.Bytes != 36 {
+		t.Errorf("events: got %+v", s)
+	}
+	if s := stats[Profile]; s.Count != 1 {
+		t.Errorf("profiler: got %+v", s)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/aukletio/Auklet-Client-C/app"
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/config"
+	"github.com/aukletio/Auklet-Client-C/errorlog"
+	"github.com/aukletio/Auklet-Client-C/sink"
+)
+
+// attachCmd serves the apps that connect to a Unix socket, instead of running
+// an app, until it receives a termination signal.
+func attachCmd(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: attach <socket>")
+	}
+	l, err := app.Listen(args[0], cfg.HandshakeTimeout)
+	if err != nil {
+		return err
+	}
+	c, err := newclient(cfg)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	log.Printf("attach: listening on %v", args[0])
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, terminationSignals...)
+	go func() {
+		log.Printf("attach: received %v; stopping", <-sigs)
+		l.Close()
+	}()
+
+	c.attach(func() (attachedApp, error) { return l.Accept() })
+	return nil
+}
+
+// attachedApp is an app that connected to the client.
+type attachedApp interface {
+	exec
+	io.Closer
+}
+
+// attach serves the apps returned by accept until it fails, and returns once
+// their messages have been delivered. The apps share a session.
+func (c *client) attach(accept func() (attachedApp, error)) {
+	sess := c.start()
+	runs := make(chan broker.Message)
+	go func() {
+		var wg sync.WaitGroup
+		for {
+			a, err := accept()
+			if err != nil {
+				break
+			}
+			if c.mqtt != nil {
+				if err := c.mqtt.api.Release(a.CheckSum()); err != nil {
+					errorlog.Printf("attach: %v: %v", a, err)
+					a.Close()
+					continue
+				}
+			}
+			log.Printf("attach: serving %v", a)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer a.Close()
+				if err := c.serve(a, sess, runs); err != nil {
+					errorlog.Printf("attach: %v: %v", a, err)
+				}
+			}()
+		}
+		wg.Wait()
+		close(runs)
+	}()
+
+	sink.Tee(source(runs), sess.sinks...)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/aukletio/Auklet-Client-C/config"
+)
+
+// A command is a client subcommand that runs in place of an app. It is named
+// with the -cmd flag, so that an app of the same name can still be run, and
+// receives the arguments that follow the flags.
+type command func(cfg *config.Config, args []string) error
+
+// commands maps the names of subcommands to their implementations.
+var commands = map[string]command{
+	"attach":  attachCmd,
+	"doctor":  doctorCmd,
+	"flush":   flushCmd,
+	"gateway": gatewayCmd,
+	"queue":   queueCmd,
+	"replay":  replayCmd,
+}
+
+// commandNames returns the names of the subcommands in alphabetical order.
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
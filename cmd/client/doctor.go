@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/spf13/afero"
+
+	backend "github.com/aukletio/Auklet-Client-C/api"
+	"github.com/aukletio/Auklet-Client-C/app"
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/config"
+)
+
+// doctorCmd checks each step the client takes to reach the backend and the
+// broker, and reports which of them fail and why. If an app is given, its
+// release is checked too. The app is never run.
+func doctorCmd(cfg *config.Config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: doctor [app]")
+	}
+	if cfg.NoNetwork {
+		return errNetworkDisabled
+	}
+
+	fs := afero.NewOsFs()
+	prefix, err := selectPrefix(fs, cfg.DataDir, config.OS)
+	if err != nil {
+		return err
+	}
+	api := newAPI(cfg, prefix, fs)
+
+	d := doctor{
+		cfg: cfg,
+		api: api,
+		connect: func() error {
+			brokerCfg, err := broker.NewConfig(api)
+			if err != nil {
+				return err
+			}
+			p, err := broker.NewMQTTProducer(brokerCfg)
+			if err != nil {
+				return err
+			}
+			p.Close()
+			return nil
+		},
+		credsPath: api.CredsPath,
+		out:       os.Stdout,
+	}
+	if len(args) == 1 {
+		e, err := app.NewExec(args[0])
+		if err != nil {
+			return err
+		}
+		d.app = args[0]
+		d.checksum = e.CheckSum()
+	}
+	return d.run()
+}
+
+// doctorAPI is the part of the backend API that doctor checks.
+type doctorAPI interface {
+	dataLimiter
+	backend.Credentialer
+	Release(string) error
+	Certificates() (*tls.Config, error)
+	BrokerAddress() (string, error)
+}
+
+// doctor implements doctorCmd.
+type doctor struct {
+	cfg       *config.Config
+	api       doctorAPI
+	connect   func() error // connects to the broker, then disconnects
+	app       string       // path of the app; empty if none was given
+	checksum  string       // of the app; empty if it could not be read
+	credsPath string       // where credentials are stored
+	out       io.Writer
+}
+
+// A check is one step of the diagnosis.
+type check struct {
+	name  string
+	needs []string               // names of checks that must pass first
+	skip  string                 // if not empty, why the check is skipped
+	run   func() error           // performs the check
+	hint  func(err error) string // suggests a remedy for a failure
+}
+
+func (d doctor) checks() []check {
+	return []check{{
+		name: "configuration",
+		run: func() error {
+			for _, name := range []string{"api-key", "app-id"} {
+				if d.cfg.Value(name) == "" {
+					return fmt.Errorf("%v is not set", name)
+				}
+			}
+			return nil
+		},
+		hint: func(error) string {
+			return "set api-key in the environment or in the config file, and app-id there or on the command line"
+		},
+	}, {
+		name:  "release",
+		needs: []string{"configuration"},
+		skip: func() string {
+			if d.app == "" {
+				return "no app given"
+			}
+			return ""
+		}(),
+		run: func() error {
+			if d.checksum == "" {
+				return fmt.Errorf("could not read %v", d.app)
+			}
+			return d.api.Release(d.checksum)
+		},
+		hint: func(err error) string {
+			if backend.NotReleased(err) {
+				return "this build of the app has not been released; release it, and make sure the device runs the released binary"
+			}
+			if d.checksum == "" {
+				return "check that the app exists and is readable"
+			}
+			return d.networkHint(err)
+		},
+	}, {
+		name:  "certificates",
+		needs: []string{"configuration"},
+		run: func() error {
+			_, err := d.api.Certificates()
+			return err
+		},
+		hint: d.networkHint,
+	}, {
+		name:  "credentials",
+		needs: []string{"configuration"},
+		run: func() error {
+			creds, err := d.api.Credentials()
+			if err != nil {
+				return err
+			}
+			if creds.Password == "" {
+				return backend.ErrEmptyPassword
+			}
+			return nil
+		},
+		hint: func(err error) string {
+			if err == backend.ErrEmptyPassword {
+				return fmt.Sprintf("the backend issues a device's credentials only once, and those saved in %v are missing or incomplete; "+
+					"restore that file, or contact support to reset this device", d.credsPath)
+			}
+			return d.networkHint(err)
+		},
+	}, {
+		name:  "broker address",
+		needs: []string{"configuration"},
+		run: func() error {
+			_, err := d.api.BrokerAddress()
+			return err
+		},
+		hint: d.networkHint,
+	}, {
+		name:  "broker connection",
+		needs: []string{"certificates", "credentials", "broker address"},
+		run:   d.connect,
+		hint: func(error) string {
+			return "check that the device can reach the broker address through any firewall or proxy, " +
+				"and that its clock is correct so that certificates can be verified"
+		},
+	}, {
+		name:  "data limit",
+		needs: []string{"configuration"},
+		run: func() error {
+			_, err := d.api.DataLimit()
+			return err
+		},
+		hint: d.networkHint,
+	}}
+}
+
+// networkHint suggests a remedy for a failed request to the backend.
+func (d doctor) networkHint(err error) string {
+	switch backend.StatusCode(err) {
+	case 0:
+		return fmt.Sprintf("check that the device can reach %v, and that base-url is correct", d.cfg.BaseURL)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "check that api-key is correct and has not been revoked"
+	case http.StatusNotFound:
+		return "check that app-id is correct, and that base-url has not been changed"
+	default:
+		return "the backend may be unavailable; try again later, or contact support if the problem persists"
+	}
+}
+
+// run performs the checks in order and writes a report to d.out. It returns
+// an error if any check failed.
+func (d doctor) run() error {
+	passed := make(map[string]bool)
+	failed := 0
+	for _, c := range d.checks() {
+		skip := c.skip
+		for _, need := range c.needs {
+			if skip == "" && !passed[need] {
+				skip = need + " failed"
+			}
+		}
+		if skip != "" {
+			fmt.Fprintf(d.out, "SKIP  %v: %v\n", c.name, skip)
+			continue
+		}
+		if err := c.run(); err != nil {
+			failed++
+			fmt.Fprintf(d.out, "FAIL  %v: %v\n      hint: %v\n", c.name, err, c.hint(err))
+			continue
+		}
+		passed[c.name] = true
+		fmt.Fprintf(d.out, "PASS  %v\n", c.name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("doctor: %v checks failed", failed)
+	}
+	return nil
+}
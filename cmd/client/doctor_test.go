@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+
+	backend "github.com/aukletio/Auklet-Client-C/api"
+	"github.com/aukletio/Auklet-Client-C/config"
+)
+
+// doctorMock fails the API calls for which it has an error.
+type doctorMock struct {
+	release, certs, addr, limit error
+	password                    string
+}
+
+func (m doctorMock) Release(string) error               { return m.release }
+func (m doctorMock) Certificates() (*tls.Config, error) { return nil, m.certs }
+func (m doctorMock) BrokerAddress() (string, error)     { return "", m.addr }
+func (m doctorMock) DataLimit() (*backend.DataLimit, error) {
+	return &backend.DataLimit{}, m.limit
+}
+func (m doctorMock) Credentials() (*backend.Credentials, error) {
+	return &backend.Credentials{Password: m.password}, nil
+}
+
+func TestDoctor(t *testing.T) {
+	errNetwork := errors.New("network unreachable")
+	cases := []struct {
+		args   []string // command-line arguments
+		apiKey string
+		api    doctorMock
+		app    string
+		ok     bool
+		expect []string // substrings of the output
+	}{
+		{
+			args:   []string{"-app-id", "a"},
+			apiKey: "k",
+			api:    doctorMock{password: "p"},
+			ok:     true,
+			expect: []string{"PASS  configuration", "SKIP  release: no app given", "PASS  broker connection"},
+		},
+		{
+			args:   nil,
+			api:    doctorMock{password: "p"},
+			ok:     false,
+			expect: []string{"FAIL  configuration: api-key is not set", "SKIP  data limit: configuration failed"},
+		},
+		{
+			args:   []string{"-app-id", "a"},
+			apiKey: "k",
+			api:    doctorMock{},
+			ok:     false,
+			expect: []string{"FAIL  credentials: empty password", "issues a device's credentials only once", "SKIP  broker connection: credentials failed"},
+		},
+		{
+			args:   []string{"-app-id", "a"},
+			apiKey: "k",
+			api:    doctorMock{password: "p", certs: errNetwork},
+			app:    "app",
+			ok:     false,
+			expect: []string{"PASS  release", "FAIL  certificates: network unreachable", "check that the device can reach", "SKIP  broker connection: certificates failed"},
+		},
+	}
+
+	for i, c := range cases {
+		flags := flag.NewFlagSet("", flag.ContinueOnError)
+		cfg := config.New(flags)
+		if err := flags.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		cfg.APIKey = c.apiKey
+		var out bytes.Buffer
+		d := doctor{
+			cfg:      cfg,
+			api:      c.api,
+			connect:  func() error { return nil },
+			app:      c.app,
+			checksum: c.app,
+			out:      &out,
+		}
+		err := d.run()
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c.ok, ok, err)
+		}
+		for _, e := range c.expect {
+			if !strings.Contains(out.String(), e) {
+				t.Errorf("case %v: expected output to contain %q, got:\n%v", i, e, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/config"
+	"github.com/aukletio/Auklet-Client-C/message"
+)
+
+// flushCmd sends the stored messages to the broker without running an app,
+// subject to the data limit.
+func flushCmd(cfg *config.Config, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: flush")
+	}
+	if cfg.NoNetwork {
+		return errNetworkDisabled
+	}
+
+	fs := afero.NewOsFs()
+	prefix, err := selectPrefix(fs, cfg.DataDir, config.OS)
+	if err != nil {
+		return err
+	}
+
+	brokerCfg, err := broker.NewConfig(newAPI(cfg, prefix, fs))
+	if err != nil {
+		return err
+	}
+	producer, err := broker.NewMQTTProducer(brokerCfg)
+	if err != nil {
+		return err
+	}
+
+	return flush(
+		broker.Queue{Dir: messageDir(prefix), Fs: fs},
+		message.FilePersistor{Path: limitPath(prefix)},
+		producer,
+		os.Stdout,
+	)
+}
+
+// deliverer publishes a stream of messages and reports the outcome.
+type deliverer interface {
+	Deliver(broker.MessageSource) broker.Summary
+}
+
+// flush delivers the messages in q through a data limiter whose state is
+// kept by lim, and writes a summary to out. Messages that cannot be decoded,
+// or that would exceed the data limit, are skipped and left in q.
+func flush(q broker.Queue, lim message.Persistor, p deliverer, out io.Writer) error {
+	entries, err := q.List()
+	if err != nil {
+		return err
+	}
+
+	msgs := make(chan broker.Message)
+	skipped := 0
+	var readable []broker.Message
+	for _, e := range entries {
+		if e.Topic == "" {
+			skipped++
+			continue
+		}
+		readable = append(readable, e.Message)
+	}
+	go func() {
+		defer close(msgs)
+		for _, m := range readable {
+			msgs <- m
+		}
+	}()
+
+	// The limiter keeps the budget from the last time the client ran, so
+	// it needs no configuration from the backend.
+	s := p.Deliver(message.NewDataLimiter(lim, nil, source(msgs)))
+	skipped += len(readable) - s.Sent - s.Failed
+
+	fmt.Fprintf(out, "sent %v, skipped %v, failed %v\n", s.Sent, skipped, s.Failed)
+	if s.Failed > 0 {
+		return fmt.Errorf("flush: %v messages could not be sent", s.Failed)
+	}
+	return nil
+}
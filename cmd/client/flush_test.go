@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/fsutil"
+	"github.com/aukletio/Auklet-Client-C/message"
+)
+
+// mockDeliverer fails to publish messages of one topic.
+type mockDeliverer struct {
+	fail broker.Topic
+}
+
+func (d mockDeliverer) Deliver(in broker.MessageSource) (s broker.Summary) {
+	for m := range in.Output() {
+		if m.Topic == d.fail {
+			s.Failed++
+			continue
+		}
+		m.Remove()
+		s.Sent++
+	}
+	return
+}
+
+func TestFlush(t *testing.T) {
+	cases := []struct {
+		fail   broker.Topic
+		ok     bool
+		expect string
+		left   int // messages left in the queue
+	}{
+		{fail: "", ok: true, expect: "sent 2, skipped 1, failed 0", left: 1},
+		{fail: broker.Profile, ok: false, expect: "sent 1, skipped 1, failed 1", left: 2},
+	}
+
+	for i, c := range cases {
+		var out bytes.Buffer
+		q := newQueue(&out).Queue
+		// add an unreadable message
+		if err := fsutil.WriteFile(q.Fs.OpenFile, "msg/1-2", []byte("garbage")); err != nil {
+			t.Fatal(err)
+		}
+
+		err := flush(q, new(message.MemPersistor), mockDeliverer{fail: c.fail}, &out)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c.ok, ok, err)
+		}
+		if !strings.Contains(out.String(), c.expect) {
+			t.Errorf("case %v: expected %q, got %q", i, c.expect, out.String())
+		}
+		if entries, _ := q.List(); len(entries) != c.left {
+			t.Errorf("case %v: expected %v messages left, got %v", i, c.left, len(entries))
+		}
+	}
+}
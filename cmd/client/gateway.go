@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/config"
+	"github.com/aukletio/Auklet-Client-C/schema"
+	"github.com/aukletio/Auklet-Client-C/serial"
+	"github.com/aukletio/Auklet-Client-C/sink"
+)
+
+// gatewayCmd relays the messages that other clients send over serial devices
+// with the serial sink to the broker, until it receives SIGINT or SIGTERM.
+func gatewayCmd(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("gateway", flag.ContinueOnError)
+	lines := flags.Bool("lines", false, "read unframed JSON lines, as written by the stdout sink, instead of frames")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: gateway [-lines] <device>...")
+	}
+	if cfg.NoNetwork {
+		return errNetworkDisabled
+	}
+	opt := serial.Options{
+		Baud:        cfg.SerialBaud,
+		Parity:      cfg.SerialParity,
+		StopBits:    cfg.SerialStopBits,
+		FlowControl: cfg.SerialFlowControl,
+	}
+	if err := opt.Validate(); err != nil {
+		return err
+	}
+
+	fs := afero.NewOsFs()
+	prefix, err := selectPrefix(fs, cfg.DataDir, config.OS)
+	if err != nil {
+		return err
+	}
+	m, err := newMQTTSink(cfg, fs, prefix)
+	if err != nil {
+		return err
+	}
+	configureLogs(cfg)
+
+	pc := pollConfig(m.api, m.pollPeriod)
+	m.limiter = pc.limiter
+	go func() {
+		// There is no agent to pass emission periods to.
+		for range pc.requester {
+		}
+	}()
+
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		log.Printf("gateway: received %v; stopping", <-sig)
+		close(stop)
+	}()
+
+	g := &gateway{
+		persistor: broker.NewPersistor(messageDir(prefix), fs, pc.persistor),
+		encoding:  encodings[cfg.Encoding],
+		lines:     *lines,
+		retry:     cfg.SerialRetryInterval,
+		open: func(path string) (io.ReadWriteCloser, error) {
+			return serial.Open(path, opt)
+		},
+	}
+	g.run(*m, flags.Args(), stop)
+	return nil
+}
+
+// gateway reads messages from serial devices and stores them until they are
+// delivered to the broker.
+type gateway struct {
+	persistor interface {
+		CreateMessage(*broker.Message) error
+	}
+	encoding schema.Encoding // of payloads sent to the broker
+	lines    bool            // whether devices send lines instead of frames
+	retry    time.Duration   // how long to wait before reopening a device
+	open     func(path string) (io.ReadWriteCloser, error)
+
+	mu  sync.Mutex // serializes calls to persistor
+	out chan<- broker.Message
+}
+
+// run serves dst with the messages read from the devices at paths until stop
+// closes.
+func (g *gateway) run(dst sink.Sink, paths []string, stop <-chan struct{}) {
+	out := make(chan broker.Message)
+	g.out = out
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dst.Serve(source(out))
+	}()
+
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			g.relay(path, stop)
+		}(path)
+	}
+	wg.Wait()
+	close(out)
+	<-done
+}
+
+// relay reads messages from the device at path until stop closes, reopening
+// the device whenever reading from it fails.
+func (g *gateway) relay(path string, stop <-chan struct{}) {
+	for {
+		port, err := g.open(path)
+		if err == nil {
+			read := make(chan error, 1)
+			go func() { read <- g.read(port) }()
+			select {
+			case err = <-read:
+				port.Close()
+			case <-stop:
+				// Closing the port interrupts the read.
+				port.Close()
+				<-read
+				return
+			}
+		}
+		log.Printf("gateway: %v: %v", path, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(g.retry):
+		}
+	}
+}
+
+// read ingests the messages read from port until reading fails.
+func (g *gateway) read(port io.ReadWriter) error {
+	if !g.lines {
+		return serial.Receive(port, g.ingest)
+	}
+	lines := bufio.NewScanner(port)
+	lines.Buffer(nil, serial.MaxPayload)
+	for lines.Scan() {
+		if len(lines.Bytes()) == 0 {
+			continue
+		}
+		if err := g.ingest(lines.Bytes()); err != nil {
+			// Nobody can be asked to send it again.
+			log.Printf("gateway: dropping message: %v", err)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// ingest stores the message in b and passes it on for delivery. The message
+// is not acknowledged unless ingest returns nil, so that the sending client
+// keeps it.
+func (g *gateway) ingest(b []byte) error {
+	msg, err := sink.Decode(b, g.encoding)
+	if err != nil {
+		// Sending it again would not help.
+		log.Printf("gateway: dropping message: %v", err)
+		return nil
+	}
+	g.mu.Lock()
+	err = g.persistor.CreateMessage(&msg)
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	g.out <- msg
+	return nil
+}
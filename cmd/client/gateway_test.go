@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/schema"
+	"github.com/aukletio/Auklet-Client-C/serial"
+)
+
+// collectSink sends the messages it is served to msgs.
+type collectSink struct {
+	msgs chan<- broker.Message
+}
+
+func (s collectSink) Serve(in broker.MessageSource) {
+	for m := range in.Output() {
+		s.msgs <- m
+	}
+	close(s.msgs)
+}
+
+func TestGateway(t *testing.T) {
+	const msg = `{"topic":"c/events/","payload":{"exitStatus":3}}`
+	cases := []struct {
+		lines bool
+		send  func(port io.ReadWriteCloser) error
+	}{
+		{
+			lines: false,
+			send: func(port io.ReadWriteCloser) error {
+				s := serial.Sender{
+					Open:       func() (io.ReadWriteCloser, error) { return port, nil },
+					AckTimeout: time.Second,
+				}
+				return s.Send([]byte(msg))
+			},
+		},
+		{
+			lines: true,
+			send: func(port io.ReadWriteCloser) error {
+				_, err := io.WriteString(port, "\n"+msg+"\n")
+				return err
+			},
+		},
+	}
+	for i, c := range cases {
+		local, remote := net.Pipe()
+		opened := false
+		fs := afero.NewMemMapFs()
+		g := &gateway{
+			persistor: broker.NewPersistor(".auklet/message", fs, nil),
+			encoding:  schema.MsgPack,
+			lines:     c.lines,
+			retry:     time.Hour,
+			open: func(string) (io.ReadWriteCloser, error) {
+				if opened {
+					return nil, errors.New("no such device")
+				}
+				opened = true
+				return remote, nil
+			},
+		}
+		msgs := make(chan broker.Message, 1)
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			g.run(collectSink{msgs}, []string{"/dev/ttyUSB0"}, stop)
+		}()
+
+		if err := c.send(local); err != nil {
+			t.Errorf("case %v: %v", i, err)
+		}
+		m := <-msgs
+		close(stop)
+		<-done
+		local.Close()
+
+		if m.Topic != broker.Event {
+			t.Errorf("case %v: expected topic %v, got %v", i, broker.Event, m.Topic)
+		}
+		v, err := schema.Decode(m.Bytes)
+		if err != nil {
+			t.Errorf("case %v: %v", i, err)
+			continue
+		}
+		if status := v.(map[string]interface{})["exitStatus"]; fmt.Sprint(status) != "3" {
+			t.Errorf("case %v: expected exit status 3, got %#v", i, status)
+		}
+		// The message stays stored until the sink removes it.
+		entries, err := broker.Queue{Dir: ".auklet/message", Fs: fs}.List()
+		if err != nil || len(entries) != 1 {
+			t.Errorf("case %v: expected 1 stored message, got %v: %v", i, len(entries), err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/aukletio/Auklet-Client-C/agent"
+	"github.com/aukletio/Auklet-Client-C/app"
+	"github.com/aukletio/Auklet-Client-C/errorlog"
+)
+
+// inspect returns a function that describes exec, whose agent is hung, and
+// kills it if c is so configured.
+func (c *client) inspect(exec exec, cmd *agent.Commander) func(*agent.Hang) {
+	return func(h *agent.Hang) {
+		log.Printf("%v: no profile within %v of a request", exec, c.hangWindow)
+		if p, ok := exec.(interface{ Pid() int }); ok && p.Pid() != 0 {
+			if state, err := app.ReadProcState(p.Pid()); err != nil {
+				errorlog.Printf("%v: %v", exec, err)
+			} else {
+				h.Process, _ = json.Marshal(state)
+			}
+		}
+		// A hung agent may still answer commands from another thread.
+		if exec.Handshake().Uses(agent.StackDump) {
+			dump, err := cmd.Do(agent.StackDump, nil, commandTimeout)
+			if err != nil {
+				errorlog.Printf("%v: %v", exec, err)
+			}
+			h.StackDump = dump
+		}
+		if c.hangKill {
+			log.Printf("%v: killing hung app", exec)
+			if err := exec.SendSignal(os.Kill); err != nil {
+				errorlog.Printf("%v: %v", exec, err)
+			}
+		}
+	}
+}
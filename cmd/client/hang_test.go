@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/aukletio/Auklet-Client-C/agent"
+)
+
+// hungExec is the current process, which is killed by sending a signal to it.
+type hungExec struct {
+	*mockExec
+	sigs []os.Signal
+}
+
+func (e *hungExec) Pid() int { return os.Getpid() }
+
+func (e *hungExec) SendSignal(sig os.Signal) error {
+	e.sigs = append(e.sigs, sig)
+	return nil
+}
+
+func TestInspect(t *testing.T) {
+	for _, kill := range []bool{false, true} {
+		e := &hungExec{mockExec: newMockExec()}
+		c := client{hangKill: kill}
+		var h agent.Hang
+		c.inspect(e, agent.NewCommander(&bytes.Buffer{}, e.Handshake()))(&h)
+
+		var p struct{ Pid int }
+		if err := json.Unmarshal(h.Process, &p); err != nil || p.Pid != os.Getpid() {
+			t.Errorf("kill %v: expected process state, got %s: %v", kill, h.Process, err)
+		}
+		if killed := len(e.sigs) == 1 && e.sigs[0] == os.Kill; killed != kill {
+			t.Errorf("kill %v: got signals %v", kill, e.sigs)
+		}
+	}
+}
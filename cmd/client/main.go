@@ -8,7 +8,10 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gobuffalo/packr"
@@ -23,6 +26,8 @@ import (
 	"github.com/aukletio/Auklet-Client-C/errorlog"
 	"github.com/aukletio/Auklet-Client-C/message"
 	"github.com/aukletio/Auklet-Client-C/schema"
+	"github.com/aukletio/Auklet-Client-C/serial"
+	"github.com/aukletio/Auklet-Client-C/sink"
 	"github.com/aukletio/Auklet-Client-C/version"
 )
 
@@ -31,66 +36,99 @@ func main() {
 	flags.SetOutput(os.Stdout)
 	flags.Usage = func() {
 		fmt.Printf("Usage of %v:\n", os.Args[0])
-		fmt.Println("All non-flag arguments are treated as a command to run.")
+		fmt.Println("All non-flag arguments are treated as a command to run, unless")
+		fmt.Println("-cmd names one of the following client commands, in which case")
+		fmt.Println("they are the arguments of the client command:")
+		for _, name := range commandNames() {
+			fmt.Printf("  %v\n", name)
+		}
 		flags.PrintDefaults()
 	}
+	cfg := config.New(flags)
 	var (
-		baseURL      string
-		userVersion  string
 		viewLicenses bool
-		noNetwork    bool
-		serialOut    string
+		printConfig  bool
+		configPath   string
+		cmdName      string
 	)
-	flags.StringVar(&baseURL, "base-url", "", "Auklet API URL; do not change unless instructed by support")
-	flags.StringVar(&userVersion, "version", "", "user-defined version string")
-	flags.StringVar(&serialOut, "serial-out", "", "address of serial device to write JSON")
 	flags.BoolVar(&viewLicenses, "licenses", false, "view OSS licenses")
-	flags.BoolVar(&noNetwork, "no-network", false, "disable network communication")
+	flags.StringVar(&configPath, "config", "", "path to config file (default "+config.DefaultPath+")")
+	flags.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+	flags.StringVar(&cmdName, "cmd", "", "run this client command instead of an app")
 
 	err := flags.Parse(os.Args[1:])
-	switch {
-	case err != nil:
+	if err != nil {
 		log.Fatal(err)
+	}
+	path, explicit := config.OS.Path(configPath)
+	if err := cfg.Load(config.OS, path, explicit, ioutil.ReadFile); err != nil {
+		log.Fatal(err)
+	}
 
+	switch {
 	case viewLicenses:
 		licenses()
 		os.Exit(0)
 
-	case len(flags.Args()) == 0:
-		flags.Usage()
-		os.Exit(1)
-	}
-
-	pipeline := func() interface{ run(exec) error } {
-		if serialOut != "" {
-			return newserial(serialOut, userVersion)
+	case cmdName != "":
+		cmd, ok := commands[cmdName]
+		if !ok {
+			log.Fatalf("unknown client command %q", cmdName)
 		}
-		if noNetwork {
-			return dumper{}
+		if err := cmd(cfg, flags.Args()); err != nil {
+			log.Fatal(err)
 		}
-		p, err := newclient(userVersion, baseURL)
-		if err != nil {
+		os.Exit(0)
+
+	case printConfig:
+		if err := cfg.Print(os.Stdout); err != nil {
 			log.Fatal(err)
 		}
-		return p
-	}()
+		os.Exit(0)
+
+	case cfg.Manifest != "" && len(flags.Args()) > 0:
+		log.Fatal("an app cannot be given along with a manifest")
+
+	case cfg.Manifest == "" && len(flags.Args()) == 0:
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	pipeline, err := newclient(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	log.Printf("Auklet Client version %s (%s)\n", version.Version, version.BuildDate)
-	e, err := app.NewExec(flags.Args()[0], flags.Args()[1:]...)
+	sups, err := newSupervisors(cfg, flags.Args())
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := pipeline.run(e); err != nil {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, terminationSignals...)
+	code, err := drain(pipeline, sups, sigs, cfg.DrainTimeout)
+	if err != nil {
 		log.Fatal(err)
 	}
+
+	if !cfg.IgnoreExitStatus {
+		// Report the app's fate to our parent as if it had run the app
+		// directly.
+		os.Exit(code)
+	}
 }
 
-func configureLogs(env config.Getenv) {
-	if !env.LogInfo() {
+// pipeline serves the runs of one or more apps.
+type pipeline interface {
+	run(...*supervisor) error
+}
+
+func configureLogs(cfg *config.Config) {
+	if !cfg.LogInfo {
 		log.SetOutput(ioutil.Discard)
 	}
-	if !env.LogErrors() {
+	if !cfg.LogErrors {
 		errorlog.SetOutput(ioutil.Discard)
 	}
 }
@@ -110,155 +148,110 @@ func licenses() {
 
 type exec interface {
 	schema.ExitSignalApp
+	ExitCode() int
+	SendSignal(os.Signal) error
 	Connect() error
 	Run() error
+	Handshake() agent.Handshake
 	AgentData() io.ReadWriter
 	Decoder() *json.Decoder
 	AppLogs() io.Reader
 }
 
-type dumper struct{}
-
-func (dumper) run(e exec) error {
-	if err := e.Connect(); err != nil {
-		return err
-	}
-
-	server := agent.NewServer(e.AgentData(), e.Decoder())
-	logger := agent.NewLogger(e.AppLogs())
-	agent.NewPeriodicRequester(e.AgentData(), server.Done, nil)
-	for m := range agent.Merge(server, logger).Output() {
-		// dump the contents
-		fmt.Printf(`type: %v
-data: %v
-error: %v
-
-`, m.Type, string(m.Data), m.Error)
-	}
-	return nil
-}
-
-type serial struct {
+type client struct {
+	mqtt        *mqttSink   // nil unless messages go to the broker
+	sinks       []sink.Sink // other destinations for messages
 	userVersion string
+	username    string
 	appID       string
+	appName     string // empty unless the app is listed in a manifest
 	macHash     string
-	addr        string // address of serial device
-	fs          afero.Fs
+	encoding    schema.Encoding
+	sampleRate  int           // requested from agents that support it, if not 0
+	hangWindow  time.Duration // 0 disables hang detection
+	hangKill    bool          // kill hung apps
+	limits      agent.Limits  // on the messages of each agent
+	logLevel    agent.Level   // least severe app log entries sent
+	outputRate  int           // entries per second from captured output; 0 means no limit
+	breadcrumbs schema.BreadcrumbLimits
+	perf        agent.PerfRules // for the app's process; a zero Period disables them
+	noRelease   bool            // serve apps without checking that they are released
 }
 
-func newserial(addr, userVersion string) serial {
-	return serial{
-		userVersion: userVersion,
-		appID:       config.OS.AppID(),
-		macHash:     device.IfaceHash(),
-		addr:        addr,
-		fs:          afero.NewOsFs(),
-	}
-}
-
-func (s serial) run(e exec) error {
-	if err := e.Connect(); err != nil {
-		return err
-	}
-	server := agent.NewServer(e.AgentData(), e.Decoder())
-	converter := schema.NewConverter(
-		schema.Config{
-			Monitor:     device.NewMonitor(),
-			Persistor:   nil,
-			App:         e, // schema.ExitSignalApp
-			Username:    "",
-			UserVersion: s.userVersion,
-			AppID:       s.appID,
-			MacHash:     s.macHash,
-			Encoding:    schema.JSON,
-		},
-		server,
-		agent.NewLogger(e.AppLogs()),
-	)
-	merger := message.Merge(
-		converter,
-		agent.NewPeriodicRequester(e.AgentData(), server.Done, nil),
-	)
-
-	tryWrite := func(msg broker.Message) {
-		f, err := s.fs.OpenFile(s.addr, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
-		if err != nil {
-			log.Printf("could not open %v: %v", s.addr, err)
-			return
-		}
-		defer f.Close()
-
-		b, err := json.Marshal(struct {
-			Topic   string          `json:"topic"`
-			Payload json.RawMessage `json:"payload"`
-		}{
-			Topic:   fmt.Sprintf("c/%v/", msg.Topic),
-			Payload: msg.Bytes,
-		})
-
-		if err != nil {
-			log.Printf("could not serialize message: %v", err)
-			return
-		}
-
-		if _, err = f.Write(append(b, []byte("\r\n")...)); err != nil {
-			log.Printf("could not write to %v: %v", s.addr, err)
-		}
-	}
-
-	for msg := range merger.Output() {
-		tryWrite(msg)
-	}
-	return nil
-}
-
-type client struct {
+// mqttSink sends messages to the broker, subject to the data limit, along
+// with the messages stored by earlier runs of the client.
+type mqttSink struct {
 	msgPath      string // directory for storing unsent messages
 	limPersistor message.Persistor
 	api          interface {
 		dataLimiter
 		Release(string) error
 	}
-	userVersion string
-	username    string
-	appID       string
-	macHash     string
-	producer    interface{ Serve(broker.MessageSource) }
-	fs          broker.Fs
+	pollPeriod time.Duration
+	producer   interface{ Serve(broker.MessageSource) }
+	fs         broker.Fs
+	limiter    <-chan backend.CellularConfig
 }
 
-func selectPrefix(fs afero.Fs, env config.Getenv) (string, error) {
+func (m mqttSink) Serve(in broker.MessageSource) {
+	m.producer.Serve(
+		message.NewDataLimiter(
+			m.limPersistor,
+			m.limiter,
+			in,
+			broker.NewMessageLoader(m.msgPath, m.fs),
+		),
+	)
+}
+
+// encodings maps the names accepted by the encoding setting to encodings.
+var encodings = map[string]schema.Encoding{
+	"msgpack": schema.MsgPack,
+	"json":    schema.JSON,
+}
+
+// selectPrefix returns the directory under which the .auklet directory is
+// created. If dataDir is empty, the working directory and $HOME are tried in
+// turn, with a temporary directory as a last resort.
+func selectPrefix(fs afero.Fs, dataDir string, env config.Getenv) (string, error) {
 	prefixes := []string{
 		"./",              // pwd
 		env("HOME") + "/", // $HOME
 	}
+	if dataDir != "" {
+		prefixes = []string{dataDir + "/"}
+	}
 	for _, prefix := range prefixes {
 		if err := fs.MkdirAll(prefix+".auklet", 0777); err == nil {
 			return prefix, nil
 		}
 	}
-	return afero.TempDir(fs, "", "auklet-")
+	dir, err := afero.TempDir(fs, "", "auklet-")
+	return dir + "/", err
 }
 
-func newclient(userVersion string, baseURL string) (*client, error) {
-	env := config.OS
-	fs := afero.NewOsFs()
+// messageDir returns the directory under prefix in which unsent messages are
+// stored.
+func messageDir(prefix string) string { return prefix + ".auklet/message" }
 
-	prefix, err := selectPrefix(fs, env)
-	if err != nil {
-		errorlog.Print(err)
-	} else {
-		log.Printf("selected prefix %q", prefix)
-	}
+// serialDir returns the directory under prefix in which messages not yet
+// acknowledged by the serial device at path are stored.
+func serialDir(prefix, path string) string {
+	return prefix + ".auklet/serial-" + filepath.Base(path)
+}
 
-	appID := env.AppID()
-	macHash := device.IfaceHash()
+// limitPath returns the path of the file under prefix in which the state of
+// the data limiter is stored.
+func limitPath(prefix string) string { return prefix + ".auklet/datalimit.json" }
 
-	api := backend.API{
-		BaseURL: env.BaseURL(baseURL),
-		Key:     env.APIKey(),
-		AppID:   appID,
-		MacHash: macHash,
+// newAPI returns an interface to the backend that keeps its credentials under
+// prefix.
+func newAPI(cfg *config.Config, prefix string, fs afero.Fs) backend.API {
+	return backend.API{
+		BaseURL: cfg.BaseURL,
+		Key:     cfg.APIKey,
+		AppID:   cfg.AppID,
+		MacHash: device.IfaceHash(),
 
 		CredsPath: prefix + ".auklet/identification",
 		Fs:        fs,
@@ -269,80 +262,405 @@ func newclient(userVersion string, baseURL string) (*client, error) {
 		ConfigEP:       backend.ConfigEP,
 		DataLimitEP:    backend.DataLimitEP,
 	}
+}
+
+// parseSinks returns the sinks named by specs, except for the mqtt sink,
+// whose presence is reported by mqtt. Sinks that store messages do so under
+// prefix.
+func parseSinks(cfg *config.Config, specs []string, fs afero.Fs, prefix string) (sinks []sink.Sink, mqtt bool, err error) {
+	for _, spec := range specs {
+		switch {
+		case spec == "mqtt":
+			if cfg.NoNetwork {
+				return nil, false, fmt.Errorf("sink %v: %v", spec, errNetworkDisabled)
+			}
+			mqtt = true
+		case spec == "stdout":
+			sinks = append(sinks, sink.Writer{W: os.Stdout})
+		case strings.HasPrefix(spec, "file:"):
+			sinks = append(sinks, sink.File{
+				Path:    strings.TrimPrefix(spec, "file:"),
+				MaxSize: cfg.SinkFileMaxSize,
+				Backups: cfg.SinkFileBackups,
+				Fs:      fs,
+			})
+		case strings.HasPrefix(spec, "serial:"):
+			path := strings.TrimPrefix(spec, "serial:")
+			opt := serial.Options{
+				Baud:        cfg.SerialBaud,
+				Parity:      cfg.SerialParity,
+				StopBits:    cfg.SerialStopBits,
+				FlowControl: cfg.SerialFlowControl,
+			}
+			if err := opt.Validate(); err != nil {
+				return nil, false, err
+			}
+			limit := make(chan *int64, 1)
+			limit <- &cfg.SerialQueueLimit
+			dir := serialDir(prefix, path)
+			sinks = append(sinks, sink.Serial{
+				Sender: &serial.Sender{
+					Open: func() (io.ReadWriteCloser, error) {
+						return serial.Open(path, opt)
+					},
+					AckTimeout: cfg.SerialAckTimeout,
+					Retries:    cfg.SerialRetries,
+				},
+				Persistor:     broker.NewPersistor(dir, fs, limit),
+				Queue:         broker.Queue{Dir: dir, Fs: fs},
+				RetryInterval: cfg.SerialRetryInterval,
+			})
+		case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+			if cfg.NoNetwork {
+				return nil, false, fmt.Errorf("sink %v: %v", spec, errNetworkDisabled)
+			}
+			sinks = append(sinks, sink.HTTP{URL: spec})
+		default:
+			return nil, false, fmt.Errorf("unknown sink %q", spec)
+		}
+	}
+	return sinks, mqtt, nil
+}
 
-	cfg, err := broker.NewConfig(api)
+// storesMessages reports whether any of the sinks named by specs stores
+// messages until they are delivered.
+func storesMessages(specs []string) bool {
+	for _, spec := range specs {
+		if spec == "mqtt" || strings.HasPrefix(spec, "serial:") {
+			return true
+		}
+	}
+	return false
+}
+
+func newclient(cfg *config.Config) (*client, error) {
+	fs := afero.NewOsFs()
+	specs := cfg.Sinks()
+
+	var prefix string
+	if storesMessages(specs) {
+		p, err := selectPrefix(fs, cfg.DataDir, config.OS)
+		if err != nil {
+			errorlog.Print(err)
+		} else {
+			log.Printf("selected prefix %q", p)
+		}
+		prefix = p
+	}
+
+	sinks, mqtt, err := parseSinks(cfg, specs, fs, prefix)
 	if err != nil {
 		return nil, err
 	}
+	logLevel, err := agent.ParseLevel(cfg.AppLogLevel)
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		sinks:       sinks,
+		userVersion: cfg.UserVersion,
+		appID:       cfg.AppID,
+		macHash:     device.IfaceHash(),
+		encoding:    schema.JSON,
+		sampleRate:  cfg.SampleRate,
+		hangWindow:  cfg.HangWindow,
+		hangKill:    cfg.HangKill,
+		logLevel:    logLevel,
+		outputRate:  cfg.OutputRateLimit,
+		perf: agent.PerfRules{
+			Period:       cfg.PerfSamplePeriod,
+			LeakGrowth:   cfg.LeakGrowth,
+			LeakWindow:   cfg.LeakWindow,
+			CPUThreshold: cfg.CPURunaway,
+			CPUDuration:  cfg.CPURunawayDuration,
+		},
+		breadcrumbs: schema.BreadcrumbLimits{
+			Count: cfg.Breadcrumbs,
+			Bytes: cfg.BreadcrumbBytes,
+		},
+		limits: agent.Limits{
+			MaxMessageSize: cfg.AgentMaxMessageSize,
+			Buffer:         cfg.AgentBuffer,
+		},
+	}
+	if !mqtt {
+		configureLogs(cfg)
+		return c, nil
+	}
 
-	producer, err := broker.NewMQTTProducer(cfg)
+	m, err := newMQTT(cfg, fs, prefix)
 	if err != nil {
 		return nil, err
 	}
 
-	configureLogs(env)
-	return &client{
-		msgPath:      prefix + ".auklet/message",
-		limPersistor: message.FilePersistor{Path: prefix + ".auklet/datalimit.json"},
+	configureLogs(cfg)
+	c.mqtt = m
+	c.encoding = encodings[cfg.Encoding]
+	return c, nil
+}
+
+var newMQTT = newMQTTSink
+
+// newMQTTSink connects to the broker and returns an mqttSink that keeps its
+// messages and state under prefix.
+func newMQTTSink(cfg *config.Config, fs afero.Fs, prefix string) (*mqttSink, error) {
+	api := newAPI(cfg, prefix, fs)
+
+	brokerCfg, err := broker.NewConfig(api)
+	if err != nil {
+		return nil, err
+	}
+
+	producer, err := broker.NewMQTTProducer(brokerCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mqttSink{
+		msgPath:      messageDir(prefix),
+		limPersistor: message.FilePersistor{Path: limitPath(prefix)},
 		api:          api,
-		userVersion:  userVersion,
-		appID:        appID,
-		macHash:      macHash,
+		pollPeriod:   cfg.PollPeriod,
 		producer:     producer,
 		fs:           fs,
 	}, nil
 }
 
-func (c *client) run(exec exec) error {
-	err := c.api.Release(exec.CheckSum())
-	if err != nil {
-		errorlog.Print(err)
-		// not released. Start the app, but don't serve it.
-		return exec.Run()
+// run serves the apps run by sups until they have all finished. Apps that
+// are not released are run, but not served.
+func (c *client) run(sups ...*supervisor) error {
+	var served, unserved []*supervisor
+	for _, s := range sups {
+		if c.mqtt != nil && !c.noRelease {
+			if err := c.mqtt.api.Release(s.current().CheckSum()); err != nil {
+				errorlog.Print(err)
+				unserved = append(unserved, s)
+				continue
+			}
+		}
+		served = append(served, s)
 	}
 
-	if c.producer == nil {
-		return nil
+	// Every run of every app feeds the same session.
+	var sess session
+	if len(served) > 0 {
+		sess = c.start()
+	}
+	runs := make(chan broker.Message)
+	errc := make(chan error, len(sups))
+	var wg sync.WaitGroup
+	wg.Add(len(sups))
+	for _, s := range unserved {
+		go func(s *supervisor) {
+			defer wg.Done()
+			errc <- s.run(func(e exec) error { return e.Run() })
+		}(s)
+	}
+	for _, s := range served {
+		go func(s *supervisor, c *client) {
+			defer wg.Done()
+			errc <- s.run(func(e exec) error {
+				return c.serve(e, sess, runs)
+			})
+		}(s, c.forApp(s.app))
+	}
+	go func() {
+		wg.Wait()
+		close(runs)
+	}()
+
+	sink.Tee(source(runs), sess.sinks...)
+	var err error
+	for range sups {
+		if e := <-errc; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// session holds what the apps served by a client at the same time, or one
+// after another, share.
+type session struct {
+	sinks     []sink.Sink
+	persistor schema.Persistor // nil unless messages go to the broker
+	periods   periods
+}
+
+// start returns a session that feeds c's sinks and, if messages go to the
+// broker, the broker, subject to a single persistor and data limiter.
+func (c *client) start() session {
+	sess := session{sinks: c.sinks}
+	if c.mqtt != nil {
+		cfg := pollConfig(c.mqtt.api, c.mqtt.pollPeriod) // dataLimiter
+		sess.persistor = broker.NewPersistor(c.mqtt.msgPath, c.mqtt.fs, cfg.persistor)
+		sess.periods = relayPeriods(cfg.requester)
+
+		m := *c.mqtt
+		m.limiter = cfg.limiter
+		sess.sinks = append(sess.sinks, m)
 	}
+	return sess
+}
 
+// serve connects to a single run of the app and sends its messages to out
+// until the app exits.
+func (c *client) serve(exec exec, sess session, out chan<- broker.Message) error {
 	if err := exec.Connect(); err != nil {
-		return err
+		herr, ok := err.(app.HandshakeError)
+		if !ok {
+			return err
+		}
+		c.fail(exec, sess, herr, out)
+		// Wait for the app to exit.
+		exec.ExitStatus()
+		return nil
 	}
 
-	cfg := pollConfig(c.api) // dataLimiter
+	period := sess.periods.next()
+	defer sess.periods.release(period)
 
 	// main source of messages
-	server := agent.NewServer(exec.AgentData(), exec.Decoder())
+	server := agent.NewServer(exec.AgentData(), exec.Decoder(), exec.Handshake(), c.limits)
+	cmd := agent.NewCommander(exec.AgentData(), exec.Handshake())
+	if c.sampleRate > 0 {
+		go c.setSampleRate(exec, cmd)
+	}
 
-	c.producer.Serve(
-		message.NewDataLimiter(
-			c.limPersistor,
-			cfg.limiter,
-			schema.NewConverter(
-				schema.Config{
-					Monitor:     device.NewMonitor(),
-					Persistor:   broker.NewPersistor(c.msgPath, c.fs, cfg.persistor),
-					App:         exec, // schema.ExitSignalApp
-					Username:    c.username,
-					UserVersion: c.userVersion,
-					AppID:       c.appID,
-					MacHash:     c.macHash,
-					Encoding:    schema.MsgPack,
-				},
-				server,
-				agent.NewLogger(exec.AppLogs()),
-			),
-			broker.NewMessageLoader(c.msgPath, c.fs),
-			agent.NewPeriodicRequester(
-				exec.AgentData(),
-				server.Done,
-				cfg.requester,
-			),
-		),
+	var messages agent.MessageSource = cmd.Serve(server)
+	var emitter agent.Emitter = cmd
+	if c.hangWindow > 0 {
+		w := agent.NewWatchdog(c.hangWindow, c.inspect(exec, cmd))
+		messages = w.Watch(messages)
+		emitter = w.Emitter(cmd)
+	}
+
+	inputs := append(c.outputLoggers(exec),
+		messages,
+		agent.NewLogger(exec.AppLogs(), c.logLevel),
 	)
+	if perf := c.perfMonitor(exec, emitter, server.Done); perf != nil {
+		inputs = append(inputs, perf)
+	}
+
+	cfg := c.schemaConfig(exec, sess)
+	cfg.Monitor = device.NewMonitor()
+	sources := []broker.MessageSource{
+		schema.NewConverter(cfg, inputs...),
+	}
+	// Agents that do not take requests emit profiles at their own pace.
+	if exec.Handshake().Uses(agent.Emit) {
+		sources = append(sources, agent.NewPeriodicRequester(
+			emitter,
+			server.Done,
+			period,
+		))
+	}
+	merger := message.Merge(sources...)
+	for msg := range merger.Output() {
+		out <- msg
+	}
 	return nil
 }
 
+// outputLoggers returns Loggers for the standard output and error of exec, if
+// they are captured.
+func (c *client) outputLoggers(exec exec) []agent.MessageSource {
+	o, ok := exec.(interface {
+		Stdout() io.Reader
+		Stderr() io.Reader
+	})
+	if !ok {
+		return nil
+	}
+	var loggers []agent.MessageSource
+	for _, s := range []struct {
+		name  string
+		in    io.Reader
+		level agent.Level
+	}{
+		{"stdout", o.Stdout(), agent.Info},
+		{"stderr", o.Stderr(), agent.Error},
+	} {
+		if s.in != nil {
+			loggers = append(loggers, agent.NewOutputLogger(s.in, s.name, s.level, c.logLevel, c.outputRate))
+		}
+	}
+	return loggers
+}
+
+// perfMonitor returns a PerfMonitor for the process of exec, or nil if it has
+// none or c does not sample it. The monitor requests a profile when it finds
+// an issue, if the agent takes requests.
+func (c *client) perfMonitor(exec exec, emitter agent.Emitter, done <-chan struct{}) *agent.PerfMonitor {
+	p, ok := exec.(interface{ Pid() int })
+	if !ok || p.Pid() == 0 || c.perf.Period <= 0 {
+		return nil
+	}
+	if !exec.Handshake().Uses(agent.Emit) {
+		emitter = nil
+	}
+	pid := p.Pid()
+	sample := func() (agent.Sample, error) {
+		m, err := device.ReadProcessMetrics(pid)
+		if err != nil {
+			return agent.Sample{}, err
+		}
+		return agent.Sample{
+			Time:    time.Now().UnixNano() / int64(time.Millisecond),
+			RSS:     m.RSS,
+			CPUTime: m.UserTime + m.SystemTime,
+		}, nil
+	}
+	return agent.NewPerfMonitor(c.perf, sample, emitter, done)
+}
+
+// commandTimeout is how long to wait for an agent to reply to a command.
+const commandTimeout = 5 * time.Second
+
+// setSampleRate asks the agent of exec to profile at c's sample rate.
+func (c *client) setSampleRate(exec exec, cmd *agent.Commander) {
+	_, err := cmd.Do(agent.SetSampleRate, agent.SampleRate{Hz: c.sampleRate}, commandTimeout)
+	if err == agent.ErrUnsupported {
+		log.Printf("%v: agent does not support setting the sample rate", exec)
+	} else if err != nil {
+		errorlog.Printf("%v: %v", exec, err)
+	}
+}
+
+// schemaConfig returns the settings for converting the messages of exec.
+func (c *client) schemaConfig(exec exec, sess session) schema.Config {
+	return schema.Config{
+		Persistor:   sess.persistor,
+		App:         exec, // schema.ExitSignalApp
+		Username:    c.username,
+		UserVersion: c.userVersion,
+		AppID:       c.appID,
+		AppName:     c.appName,
+		MacHash:     c.macHash,
+		Encoding:    c.encoding,
+		Breadcrumbs: c.breadcrumbs,
+	}
+}
+
+// fail sends a log message reporting err, which keeps exec from being
+// monitored, to out.
+func (c *client) fail(exec exec, sess session, err error, out chan<- broker.Message) {
+	errorlog.Printf("%v", err)
+	msg := schema.Failure(c.schemaConfig(exec, sess), err)
+	if sess.persistor != nil {
+		if err := sess.persistor.CreateMessage(&msg); err != nil {
+			errorlog.Print(err)
+		}
+	}
+	out <- msg
+}
+
+// source is a broker.MessageSource backed by a channel.
+type source <-chan broker.Message
+
+func (s source) Output() <-chan broker.Message { return s }
+
 type dataLimiter interface {
 	DataLimit() (*backend.DataLimit, error)
 }
@@ -353,9 +671,9 @@ type configChans struct {
 	persistor chan *int64
 }
 
-// pollConfig periodically polls the backend for data-limiting parameters and
-// sends them on its output channels.
-func pollConfig(api dataLimiter) configChans {
+// pollConfig polls the backend for data-limiting parameters once per period
+// and sends them on its output channels.
+func pollConfig(api dataLimiter, period time.Duration) configChans {
 	c := configChans{
 		requester: make(chan int, 1),
 		limiter:   make(chan backend.CellularConfig, 1),
@@ -375,7 +693,7 @@ func pollConfig(api dataLimiter) configChans {
 		}
 
 		poll()
-		for _ = range time.Tick(time.Hour) {
+		for _ = range time.Tick(period) {
 			poll()
 		}
 	}()
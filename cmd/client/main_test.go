@@ -5,17 +5,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/vmihailenco/msgpack"
 
+	"github.com/aukletio/Auklet-Client-C/agent"
 	backend "github.com/aukletio/Auklet-Client-C/api"
+	"github.com/aukletio/Auklet-Client-C/app"
 	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/config"
 	"github.com/aukletio/Auklet-Client-C/message"
+	"github.com/aukletio/Auklet-Client-C/schema"
+	"github.com/aukletio/Auklet-Client-C/sink"
 )
 
 type mockExec struct {
@@ -48,8 +56,14 @@ func (m *mockExec) Decoder() *json.Decoder {
 	}
 	return m.decoder
 }
-func (mockExec) ExitStatus() int { return 0 }
-func (mockExec) Signal() string  { return "signal" }
+func (mockExec) ExitStatus() int            { return 0 }
+func (mockExec) ExitCode() int              { return 0 }
+func (mockExec) SendSignal(os.Signal) error { return nil }
+func (mockExec) Signal() string             { return "signal" }
+func (mockExec) Handshake() agent.Handshake {
+	h, _ := agent.Negotiate(agent.Hello{Version: "1.0.0"})
+	return h
+}
 
 type mockAPI struct {
 	checksum  string
@@ -93,55 +107,50 @@ func TestClient(t *testing.T) {
 	e := newMockExec()
 
 	c := client{
-		msgPath:      ".auklet/message",
-		limPersistor: &message.MemPersistor{},
-		api: mockAPI{
-			checksum: "checksum",
-			dataLimit: backend.DataLimit{
-				EmissionPeriod: 1,
-				Cellular: backend.CellularConfig{
-					Date:    1,
-					Defined: false,
-					Limit:   0,
+		mqtt: &mqttSink{
+			msgPath:      ".auklet/message",
+			limPersistor: &message.MemPersistor{},
+			api: mockAPI{
+				checksum: "checksum",
+				dataLimit: backend.DataLimit{
+					EmissionPeriod: 1,
+					Cellular: backend.CellularConfig{
+						Date:    1,
+						Defined: false,
+						Limit:   0,
+					},
 				},
 			},
+			pollPeriod: time.Hour,
+			producer:   &mockProducer{},
+			fs:         afero.NewMemMapFs(),
 		},
 		userVersion: "userVersion",
 		username:    "username",
 		appID:       "appID",
 		macHash:     "macHash",
-		producer:    &mockProducer{},
-		fs:          afero.NewMemMapFs(),
-	}
-
-	if err := c.run(e); err != nil {
-		t.Error(err)
 	}
-}
-
-func TestDumper(t *testing.T) {
-	e := newMockExec()
 
-	var d dumper
-	if err := d.run(e); err != nil {
+	if err := c.run(once(e)); err != nil {
 		t.Error(err)
 	}
 }
 
-func TestSerial(t *testing.T) {
+func TestSinks(t *testing.T) {
 	e := newMockExec()
-	addr := "serial-device"
-	s := serial{
+	addr := "out.jsonl"
+	fs := afero.NewMemMapFs()
+	c := client{
+		sinks:       []sink.Sink{sink.File{Path: addr, Fs: fs}},
 		userVersion: "userVersion",
 		appID:       "appID",
 		macHash:     "macHash",
-		addr:        addr,
-		fs:          afero.NewMemMapFs(),
+		encoding:    schema.JSON,
 	}
-	if err := s.run(e); err != nil {
+	if err := c.run(once(e)); err != nil {
 		t.Error(err)
 	}
-	f, err := s.fs.Open(addr)
+	f, err := fs.Open(addr)
 	if err != nil {
 		t.Error(err)
 	}
@@ -161,3 +170,132 @@ func TestSerial(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSinks(t *testing.T) {
+	cases := []struct {
+		args  []string
+		specs []string
+		sinks int
+		mqtt  bool
+		ok    bool
+	}{
+		{specs: []string{"mqtt"}, sinks: 0, mqtt: true, ok: true},
+		{specs: []string{"mqtt", "stdout", "file:out", "serial:/dev/ttyS0", "https://example.com/"}, sinks: 4, mqtt: true, ok: true},
+		{args: []string{"-no-network"}, specs: []string{"stdout"}, sinks: 1, ok: true},
+		{args: []string{"-no-network"}, specs: []string{"mqtt"}, ok: false},
+		{args: []string{"-no-network"}, specs: []string{"http://localhost/"}, ok: false},
+		{specs: []string{"carrier-pigeon"}, ok: false},
+		{args: []string{"-serial-parity", "mark"}, specs: []string{"serial:/dev/ttyS0"}, ok: false},
+	}
+	for i, c := range cases {
+		flags := flag.NewFlagSet("", flag.ContinueOnError)
+		cfg := config.New(flags)
+		if err := flags.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		sinks, mqtt, err := parseSinks(cfg, c.specs, afero.NewMemMapFs(), "")
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c.ok, ok, err)
+			continue
+		}
+		if len(sinks) != c.sinks || mqtt != c.mqtt {
+			t.Errorf("case %v: expected %v sinks and mqtt %v, got %v and %v", i, c.sinks, c.mqtt, len(sinks), mqtt)
+		}
+	}
+}
+
+// closeExec is a mockExec that can be disconnected.
+type closeExec struct {
+	*mockExec
+	closed chan struct{}
+}
+
+func (e closeExec) Close() error {
+	close(e.closed)
+	return nil
+}
+
+func TestAttach(t *testing.T) {
+	apps := []closeExec{
+		{newMockExec(), make(chan struct{})},
+		{newMockExec(), make(chan struct{})},
+	}
+	apps[1].checksum = "unreleased"
+	accepted := 0
+	accept := func() (attachedApp, error) {
+		if accepted == len(apps) {
+			return nil, errors.New("closed")
+		}
+		accepted++
+		return apps[accepted-1], nil
+	}
+
+	var msgs []broker.Message
+	c := client{
+		mqtt: &mqttSink{
+			msgPath:      ".auklet/message",
+			limPersistor: &message.MemPersistor{},
+			api:          mockAPI{checksum: "checksum"},
+			pollPeriod:   time.Hour,
+			producer:     collector{&msgs},
+			fs:           afero.NewMemMapFs(),
+		},
+		encoding: schema.JSON,
+	}
+	c.attach(accept)
+
+	for i, a := range apps {
+		select {
+		case <-a.closed:
+		default:
+			t.Errorf("app %v: expected to be closed", i)
+		}
+	}
+	// The released app sends a profile and its exit.
+	if len(msgs) != 2 {
+		t.Errorf("expected 2 messages, got %v", len(msgs))
+	}
+}
+
+// collector appends the messages it is served to msgs.
+type collector struct {
+	msgs *[]broker.Message
+}
+
+func (c collector) Serve(in broker.MessageSource) {
+	for m := range in.Output() {
+		*c.msgs = append(*c.msgs, m)
+	}
+}
+
+// noAgentExec is an app without an agent.
+type noAgentExec struct{ *mockExec }
+
+func (noAgentExec) Connect() error { return app.HandshakeError{Err: errors.New("no agent")} }
+
+func TestHandshakeFailure(t *testing.T) {
+	var msgs []broker.Message
+	c := client{
+		mqtt: &mqttSink{
+			msgPath:      ".auklet/message",
+			limPersistor: &message.MemPersistor{},
+			api:          mockAPI{checksum: "checksum"},
+			pollPeriod:   time.Hour,
+			producer:     collector{&msgs},
+			fs:           afero.NewMemMapFs(),
+		},
+		encoding: schema.JSON,
+	}
+	if err := c.run(once(noAgentExec{newMockExec()})); err != nil {
+		t.Fatal(err)
+	}
+	// The failure is reported, and stored until it is sent. (The stored
+	// copy may also be loaded, since nothing removes it.)
+	if len(msgs) == 0 || msgs[0].Topic != broker.Log || !strings.Contains(string(msgs[0].Bytes), "no agent") {
+		t.Errorf("expected log message reporting the failure, got %+v", msgs)
+	}
+	entries, err := broker.Queue{Dir: ".auklet/message", Fs: c.mqtt.fs}.List()
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected 1 stored message, got %v: %v", len(entries), err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/aukletio/Auklet-Client-C/app"
+	"github.com/aukletio/Auklet-Client-C/config"
+)
+
+// appConfig holds the settings that may differ between the apps run by one
+// client.
+type appConfig struct {
+	name        string
+	userVersion string
+	appID       string
+}
+
+// forApp returns a copy of c that serves the app configured by a, or c if a
+// is nil.
+func (c *client) forApp(a *appConfig) *client {
+	if a == nil {
+		return c
+	}
+	ac := *c
+	ac.appName = a.name
+	ac.userVersion = a.userVersion
+	ac.appID = a.appID
+	return &ac
+}
+
+// newSupervisors returns a supervisor for each app listed in the manifest, if
+// one is configured, or else a supervisor for the app given by args.
+func newSupervisors(cfg *config.Config, args []string) ([]*supervisor, error) {
+	if cfg.Manifest != "" {
+		apps, err := cfg.Apps(ioutil.ReadFile)
+		if err != nil {
+			return nil, err
+		}
+		return manifestSupervisors(apps, cfg)
+	}
+
+	var recorder *app.Recorder
+	if cfg.Record != "" {
+		f, err := os.OpenFile(cfg.Record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, err
+		}
+		// Records are written unbuffered, so f needs no closing.
+		recorder = app.NewRecorder(f)
+	}
+	newExec := func() (exec, error) {
+		e, err := app.NewExec(args[0], args[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		e.SetHandshakeTimeout(cfg.HandshakeTimeout)
+		if cfg.CaptureOutput {
+			e.CaptureOutput()
+		}
+		if recorder != nil {
+			e.Record(recorder)
+		}
+		return e, nil
+	}
+	s, err := newSupervisor(newExec, app.RestartPolicy{
+		Enabled:     cfg.Restart,
+		MaxRestarts: cfg.MaxRestarts,
+		Backoff:     cfg.RestartBackoff,
+		MaxBackoff:  cfg.RestartBackoffMax,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []*supervisor{s}, nil
+}
+
+// manifestSupervisors returns a supervisor for each of apps, which are run
+// with the handshake timeout and output capture given by cfg.
+func manifestSupervisors(apps []config.App, cfg *config.Config) ([]*supervisor, error) {
+	var sups []*supervisor
+	for _, a := range apps {
+		a := a
+		newExec := func() (exec, error) {
+			e, err := app.NewExec(a.Command[0], a.Command[1:]...)
+			if err != nil {
+				return nil, err
+			}
+			e.AddEnv(a.Env...)
+			e.SetHandshakeTimeout(cfg.HandshakeTimeout)
+			if cfg.CaptureOutput {
+				e.CaptureOutput()
+			}
+			return e, nil
+		}
+		s, err := newSupervisor(newExec, app.RestartPolicy{
+			Enabled:     a.Restart,
+			MaxRestarts: a.MaxRestarts,
+			Backoff:     a.RestartBackoff,
+			MaxBackoff:  a.RestartBackoffMax,
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.app = &appConfig{
+			name:        a.Name,
+			userVersion: a.UserVersion,
+			appID:       a.AppID,
+		}
+		sups = append(sups, s)
+	}
+	return sups, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/schema"
+	"github.com/aukletio/Auklet-Client-C/sink"
+)
+
+func TestManifest(t *testing.T) {
+	var sups []*supervisor
+	for _, name := range []string{"sensord", "gpsd"} {
+		s := once(newMockExec())
+		s.app = &appConfig{name: name, userVersion: name + "-1.0", appID: "fleet"}
+		sups = append(sups, s)
+	}
+	var msgs []broker.Message
+	c := client{
+		sinks:       []sink.Sink{collector{&msgs}},
+		userVersion: "userVersion",
+		appID:       "appID",
+		encoding:    schema.JSON,
+	}
+	if err := c.run(sups...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each app sends a profile and its exit, tagged with its settings.
+	count := make(map[string]int)
+	for _, m := range msgs {
+		var v struct {
+			AppName     string `json:"appName"`
+			Version     string `json:"version"`
+			Application string `json:"application"`
+		}
+		if err := json.Unmarshal(m.Bytes, &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Version != v.AppName+"-1.0" || v.Application != "fleet" {
+			t.Errorf("expected settings of %v, got %+v", v.AppName, v)
+		}
+		count[v.AppName]++
+	}
+	for _, name := range []string{"sensord", "gpsd"} {
+		if count[name] != 2 {
+			t.Errorf("expected 2 messages from %v, got %v", name, count[name])
+		}
+	}
+}
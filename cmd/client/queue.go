@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/config"
+	"github.com/aukletio/Auklet-Client-C/schema"
+)
+
+const queueUsage = `usage: queue <subcommand> [arguments]
+
+Subcommands:
+  ls                  list stored messages, oldest first
+  show <id>           show a stored message and its decoded payload
+  rm <id>...          remove stored messages
+  purge [-topic t]    remove all stored messages, or all of one topic
+  stats               summarize stored messages by topic`
+
+var errNetworkDisabled = errors.New("network communication disabled")
+
+// queueCmd inspects and manages the messages stored for later sending.
+func queueCmd(cfg *config.Config, args []string) error {
+	fs := afero.NewOsFs()
+	prefix, err := selectPrefix(fs, cfg.DataDir, config.OS)
+	if err != nil {
+		return err
+	}
+	q := queue{
+		Queue: broker.Queue{Dir: messageDir(prefix), Fs: fs},
+		out:   os.Stdout,
+		limit: func() (*int64, error) {
+			if cfg.NoNetwork {
+				return nil, errNetworkDisabled
+			}
+			dl, err := newAPI(cfg, prefix, fs).DataLimit()
+			if err != nil {
+				return nil, err
+			}
+			return dl.Storage, nil
+		},
+	}
+	return q.run(args)
+}
+
+// queue implements the subcommands of queueCmd.
+type queue struct {
+	broker.Queue
+	out   io.Writer
+	limit func() (*int64, error) // storage limit in bytes; nil if none
+}
+
+func (q queue) run(args []string) error {
+	if len(args) == 0 {
+		return errors.New(queueUsage)
+	}
+	name, args := args[0], args[1:]
+	switch name {
+	case "ls":
+		return q.ls()
+	case "show":
+		if len(args) != 1 {
+			return errors.New("usage: queue show <id>")
+		}
+		return q.show(args[0])
+	case "rm":
+		if len(args) == 0 {
+			return errors.New("usage: queue rm <id>...")
+		}
+		return q.rm(args)
+	case "purge":
+		flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+		flags.SetOutput(q.out)
+		topic := flags.String("topic", "", "remove only messages of this topic")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		return q.purge(broker.Topic(*topic))
+	case "stats":
+		return q.stats()
+	default:
+		return fmt.Errorf("unknown queue subcommand %q\n%v", name, queueUsage)
+	}
+}
+
+func (q queue) ls() error {
+	entries, err := q.List()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(q.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTOPIC\tSIZE\tSTORED\tERROR")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n",
+			e.ID, topicName(e.Topic), e.Size, e.ModTime.Format(time.RFC3339), e.Error)
+	}
+	return tw.Flush()
+}
+
+// topicName returns a printable name for t.
+func topicName(t broker.Topic) string {
+	if t == "" {
+		return "(unreadable)"
+	}
+	return string(t)
+}
+
+func (q queue) show(id string) error {
+	e, err := q.Get(id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(q.out, "id:     %v\ntopic:  %v\nsize:   %v\nstored: %v\n",
+		e.ID, topicName(e.Topic), e.Size, e.ModTime.Format(time.RFC3339))
+	if e.Error != "" {
+		fmt.Fprintf(q.out, "error:  %v\n", e.Error)
+	}
+	if len(e.Bytes) == 0 {
+		return nil
+	}
+	v, err := schema.Decode(e.Bytes)
+	if err != nil {
+		fmt.Fprintf(q.out, "payload could not be decoded (%v): %+q\n", err, e.Bytes)
+		return nil
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(q.out, "payload:\n%s\n", b)
+	return nil
+}
+
+func (q queue) rm(ids []string) error {
+	for _, id := range ids {
+		if err := q.Remove(id); err != nil {
+			return err
+		}
+		fmt.Fprintf(q.out, "removed %v\n", id)
+	}
+	return nil
+}
+
+func (q queue) purge(topic broker.Topic) error {
+	entries, err := q.List()
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, e := range entries {
+		if topic != "" && e.Topic != topic {
+			continue
+		}
+		if err := q.Remove(e.ID); err != nil {
+			return err
+		}
+		n++
+	}
+	fmt.Fprintf(q.out, "removed %v messages\n", n)
+	return nil
+}
+
+func (q queue) stats() error {
+	stats, err := q.Stats()
+	if err != nil {
+		return err
+	}
+	var topics []string
+	var total broker.Stats
+	for t, s := range stats {
+		topics = append(topics, string(t))
+		total.Count += s.Count
+		total.Bytes += s.Bytes
+	}
+	sort.Strings(topics)
+
+	tw := tabwriter.NewWriter(q.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOPIC\tCOUNT\tBYTES")
+	for _, t := range topics {
+		s := stats[broker.Topic(t)]
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", topicName(broker.Topic(t)), s.Count, s.Bytes)
+	}
+	fmt.Fprintf(tw, "total\t%v\t%v\n", total.Count, total.Bytes)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	limit, err := q.limit()
+	switch {
+	case err != nil:
+		fmt.Fprintf(q.out, "storage limit: unknown (%v)\n", err)
+	case limit == nil:
+		fmt.Fprintln(q.out, "storage limit: none")
+	case *limit <= 0:
+		fmt.Fprintln(q.out, "storage limit: 0 bytes; new messages are refused")
+	default:
+		// The persistor stops storing messages at 90% of the limit.
+		fmt.Fprintf(q.out, "storage limit: %v bytes; %.1f%% used; new messages are refused above 90%%\n",
+			*limit, 100*float64(total.Bytes)/float64(*limit))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/fsutil"
+)
+
+func newQueue(out *bytes.Buffer) queue {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		// payload is {"exitStatus":3} as JSON
+		"1-0": `{"topic":"events","bytes":"eyJleGl0U3RhdHVzIjozfQ=="}`,
+		"1-1": `{"topic":"profiler","bytes":"wQ=="}`, // 0xc1 is never valid MsgPack
+	}
+	for name, contents := range files {
+		if err := fsutil.WriteFile(fs.OpenFile, "msg/"+name, []byte(contents)); err != nil {
+			panic(err)
+		}
+	}
+	limit := int64(1000)
+	return queue{
+		Queue: broker.Queue{Dir: "msg", Fs: fs},
+		out:   out,
+		limit: func() (*int64, error) { return &limit, nil },
+	}
+}
+
+func TestQueue(t *testing.T) {
+	cases := []struct {
+		args   []string
+		ok     bool
+		expect string // substring of the output
+	}{
+		{args: nil, ok: false},
+		{args: []string{"bogus"}, ok: false},
+		{args: []string{"ls"}, ok: true, expect: "1-1"},
+		{args: []string{"show", "1-0"}, ok: true, expect: `"exitStatus": 3`},
+		{args: []string{"show", "1-1"}, ok: true, expect: "could not be decoded"},
+		{args: []string{"show", "nope"}, ok: false},
+		{args: []string{"show"}, ok: false},
+		{args: []string{"stats"}, ok: true, expect: "storage limit: 1000 bytes"},
+		{args: []string{"rm", "1-0"}, ok: true, expect: "removed 1-0"},
+		{args: []string{"rm", "nope"}, ok: false},
+		{args: []string{"purge", "-topic", "events"}, ok: true, expect: "removed 1 messages"},
+		{args: []string{"purge"}, ok: true, expect: "removed 2 messages"},
+	}
+
+	for i, c := range cases {
+		var out bytes.Buffer
+		err := newQueue(&out).run(c.args)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c.ok, ok, err)
+		}
+		if !strings.Contains(out.String(), c.expect) {
+			t.Errorf("case %v: expected output to contain %q, got:\n%v", i, c.expect, out.String())
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aukletio/Auklet-Client-C/app"
+	"github.com/aukletio/Auklet-Client-C/config"
+)
+
+// replayCmd feeds a run recorded with the record setting through the pipeline
+// selected by cfg, as if the recorded app were running. Unless the sink is
+// given on the command line, the output goes to stdout, so that recorded data
+// never reaches the backend as if it were live. The app is not checked for
+// release.
+func replayCmd(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	run := flags.Int("run", 1, "which of the recorded runs to replay, counting from 1")
+	realtime := flags.Bool("realtime", false, "replay at the recorded pace")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: replay [-run n] [-realtime] <file>")
+	}
+
+	rec, err := readRun(flags.Arg(0), *run)
+	if err != nil {
+		return err
+	}
+	if cfg.Source("sink") != config.CommandLine {
+		cfg.Sink = "stdout"
+	}
+	p, err := newclient(cfg)
+	if err != nil {
+		return err
+	}
+	p.noRelease = true
+	return p.run(once(app.NewReplay(rec, *realtime)))
+}
+
+// readRun reads the nth run recorded in the named file.
+func readRun(name string, n int) (app.Recording, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return app.Recording{}, err
+	}
+	defer f.Close()
+	runs, err := app.ReadRecordings(f)
+	if err != nil {
+		return app.Recording{}, err
+	}
+	if n < 1 || n > len(runs) {
+		return app.Recording{}, fmt.Errorf("replay: %v has %v runs; cannot replay run %v", name, len(runs), n)
+	}
+	return runs[n-1], nil
+}
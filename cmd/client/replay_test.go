@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/config"
+)
+
+const recording = `{"time":"2018-01-01T00:00:00Z","stream":"start","path":"app","checksum":"sum"}
+{"time":"2018-01-01T00:00:00Z","stream":"agent","data":"eyJ2ZXJzaW9uIjoiMS4wLjAifQ=="}
+{"time":"2018-01-01T00:00:01Z","stream":"exit","exitStatus":1,"exitCode":1}
+`
+
+func TestReplayDefaultSink(t *testing.T) {
+	f, err := ioutil.TempFile("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(recording); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	dialed := false
+	newMQTT = func(*config.Config, afero.Fs, string) (*mqttSink, error) {
+		dialed = true
+		return nil, errors.New("replay must not connect to the broker")
+	}
+	defer func() { newMQTT = newMQTTSink }()
+
+	flags := flag.NewFlagSet("", flag.ContinueOnError)
+	cfg := config.New(flags)
+	if err := flags.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	// A sink from the environment, such as that of a deployed client, is
+	// not used either.
+	getenv := func(k string) string {
+		if k == "AUKLET_SINK" {
+			return "mqtt"
+		}
+		return ""
+	}
+	noFile := func(string) ([]byte, error) { return nil, os.ErrNotExist }
+	if err := cfg.Load(getenv, "client.json", false, noFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replayCmd(cfg, []string{f.Name()}); err != nil {
+		t.Error(err)
+	}
+	if dialed {
+		t.Error("expected no connection to the broker")
+	}
+}
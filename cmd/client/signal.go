@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/aukletio/Auklet-Client-C/errorlog"
+)
+
+// terminationSignals are forwarded to the app instead of terminating the
+// client immediately.
+var terminationSignals = []os.Signal{
+	syscall.SIGTERM,
+	syscall.SIGINT,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+}
+
+// drain runs p under sups until p finishes, and returns the highest exit code
+// of the last runs of the apps.
+//
+// If a signal arrives on sigs, it is forwarded to the apps and sups stop
+// restarting them. p then has until timeout to flush or persist its messages
+// and disconnect from the broker. If p does not finish in time, the apps are
+// killed and drain returns the code of a process killed by the first signal.
+func drain(p pipeline, sups []*supervisor, sigs <-chan os.Signal, timeout time.Duration) (int, error) {
+	done := make(chan error, 1)
+	go func() { done <- p.run(sups...) }()
+
+	var (
+		first    os.Signal
+		deadline <-chan time.Time
+	)
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return 0, err
+			}
+			code := 0
+			for _, s := range sups {
+				if c := s.current().ExitCode(); c > code {
+					code = c
+				}
+			}
+			return code, nil
+		case sig := <-sigs:
+			log.Printf("received %v; forwarding to apps", sig)
+			for _, s := range sups {
+				s.stop(sig)
+			}
+			if first == nil {
+				first = sig
+				deadline = time.After(timeout)
+			}
+		case <-deadline:
+			errorlog.Printf("pipeline did not drain within %v; killing apps", timeout)
+			for _, s := range sups {
+				s.stop(syscall.SIGKILL)
+			}
+			return 128 + int(first.(syscall.Signal)), nil
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// stopPipeline finishes when its supervisor is stopped, unless it is stuck.
+type stopPipeline struct {
+	stuck bool
+}
+
+func (p stopPipeline) run(sups ...*supervisor) error {
+	for _, s := range sups {
+		<-s.stopped
+	}
+	if p.stuck {
+		select {}
+	}
+	return nil
+}
+
+func TestDrain(t *testing.T) {
+	cases := []struct {
+		p      pipeline
+		expect int
+	}{
+		{p: stopPipeline{}, expect: 0},
+		{p: stopPipeline{stuck: true}, expect: 128 + int(syscall.SIGTERM)},
+	}
+
+	for i, c := range cases {
+		sigs := make(chan os.Signal, 1)
+		sigs <- syscall.SIGTERM
+		sups := []*supervisor{once(newMockExec()), once(newMockExec())}
+		code, err := drain(c.p, sups, sigs, 10*time.Millisecond)
+		if err != nil {
+			t.Error(err)
+		}
+		if code != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, code)
+		}
+	}
+}
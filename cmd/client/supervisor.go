@@ -0,0 +1,193 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aukletio/Auklet-Client-C/app"
+	"github.com/aukletio/Auklet-Client-C/errorlog"
+)
+
+// supervisor runs an app and restarts it after crashes, as permitted by its
+// restart policy. Each run gets a new exec.
+type supervisor struct {
+	newExec func() (exec, error)
+	policy  app.RestartPolicy
+	app     *appConfig // settings of the app, if they differ from the client's
+
+	mu      sync.Mutex
+	cur     exec          // the current (or most recent) run of the app
+	stopped chan struct{} // closes when the supervisor is asked to stop
+}
+
+// newSupervisor returns a supervisor whose current exec has been created, but
+// not started.
+func newSupervisor(newExec func() (exec, error), policy app.RestartPolicy) (*supervisor, error) {
+	e, err := newExec()
+	if err != nil {
+		return nil, err
+	}
+	return &supervisor{
+		newExec: newExec,
+		policy:  policy,
+		cur:     e,
+		stopped: make(chan struct{}),
+	}, nil
+}
+
+// once returns a supervisor that runs e and never restarts it.
+func once(e exec) *supervisor {
+	return &supervisor{
+		cur:     e,
+		stopped: make(chan struct{}),
+	}
+}
+
+// current returns the current run of the app.
+func (s *supervisor) current() exec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cur
+}
+
+// run calls serve on each run of the app. serve must not return until the app
+// has exited. run returns when serve fails, or when the app exits and the
+// policy does not permit a restart.
+func (s *supervisor) run(serve func(exec) error) error {
+	for restarts := 0; ; restarts++ {
+		if s.isStopped() {
+			// The client was asked to stop before the app started.
+			return nil
+		}
+		cur := s.current()
+		if err := serve(cur); err != nil {
+			return err
+		}
+
+		status, signal := cur.ExitStatus(), cur.Signal()
+		if s.isStopped() || !s.policy.Restart(restarts, status, signal) {
+			return nil
+		}
+
+		delay := s.policy.Delay(restarts)
+		log.Printf("supervisor: %v crashed (status %v, signal %q); restart %v in %v",
+			s.name(), status, signal, restarts+1, delay)
+		select {
+		case <-time.After(delay):
+		case <-s.stopped:
+			return nil
+		}
+
+		e, err := s.newExec()
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		if s.isStopped() {
+			s.mu.Unlock()
+			return nil
+		}
+		s.cur = e
+		s.mu.Unlock()
+	}
+}
+
+// name returns the name of the app in log messages.
+func (s *supervisor) name() string {
+	if s.app == nil {
+		return "app"
+	}
+	return s.app.name
+}
+
+func (s *supervisor) isStopped() bool {
+	select {
+	case <-s.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop prevents any further restarts and delivers sig to the current run of
+// the app.
+func (s *supervisor) stop(sig os.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isStopped() {
+		close(s.stopped)
+	}
+	if err := s.cur.SendSignal(sig); err != nil {
+		errorlog.Printf("supervisor: could not deliver %v to app: %v", sig, err)
+	}
+}
+
+// periods relays emission periods from the backend to the requesters of the
+// running apps, so that a restarted or newly attached app inherits the most
+// recent period. The zero periods relays nothing.
+type periods struct {
+	in    <-chan int
+	sub   chan chan int
+	unsub chan (<-chan int)
+}
+
+func relayPeriods(in <-chan int) periods {
+	p := periods{
+		in:    in,
+		sub:   make(chan chan int),
+		unsub: make(chan (<-chan int)),
+	}
+	go p.serve()
+	return p
+}
+
+// next returns a configuration channel for a new requester. The channel
+// receives periods until it is passed to release.
+func (p periods) next() <-chan int {
+	if p.sub == nil {
+		return nil
+	}
+	c := make(chan int, 1)
+	p.sub <- c
+	return c
+}
+
+// release stops relaying periods to c, whose requester has finished.
+func (p periods) release(c <-chan int) {
+	if p.unsub == nil {
+		return
+	}
+	p.unsub <- c
+}
+
+func (p periods) serve() {
+	var (
+		subs = make(map[<-chan int]chan int)
+		last int // zero if no period has been received
+	)
+	for {
+		select {
+		case dur := <-p.in:
+			last = dur
+			for _, c := range subs {
+				// Replace any value the requester hasn't read
+				// yet.
+				select {
+				case <-c:
+				default:
+				}
+				c <- dur
+			}
+		case c := <-p.sub:
+			subs[c] = c
+			if last != 0 {
+				c <- last
+			}
+		case c := <-p.unsub:
+			delete(subs, c)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/aukletio/Auklet-Client-C/app"
+)
+
+// exitExec is an exec that exits with the given status and signal.
+type exitExec struct {
+	*mockExec
+	status int
+	signal string
+}
+
+func (e exitExec) ExitStatus() int { return e.status }
+func (e exitExec) Signal() string  { return e.signal }
+
+func crashed() (exec, error) { return exitExec{newMockExec(), 1, ""}, nil }
+func exited() (exec, error)  { return exitExec{newMockExec(), 0, ""}, nil }
+
+func TestSupervisor(t *testing.T) {
+	errNewExec := errors.New("newExec failed")
+	cases := []struct {
+		policy  app.RestartPolicy
+		newExec func() (exec, error)
+		runs    int
+		err     error
+	}{
+		{
+			// restarts disabled
+			policy:  app.RestartPolicy{},
+			newExec: crashed,
+			runs:    1,
+		},
+		{
+			policy:  app.RestartPolicy{Enabled: true, MaxRestarts: 3},
+			newExec: crashed,
+			runs:    4,
+		},
+		{
+			// clean exit after one restart
+			policy:  app.RestartPolicy{Enabled: true},
+			newExec: exited,
+			runs:    2,
+		},
+		{
+			policy:  app.RestartPolicy{Enabled: true},
+			newExec: func() (exec, error) { return nil, errNewExec },
+			runs:    1,
+			err:     errNewExec,
+		},
+	}
+
+	for i, c := range cases {
+		s := &supervisor{
+			newExec: c.newExec,
+			policy:  c.policy,
+			cur:     exitExec{newMockExec(), -1, "segmentation fault"},
+			stopped: make(chan struct{}),
+		}
+		runs := 0
+		err := s.run(func(exec) error {
+			runs++
+			return nil
+		})
+		if err != c.err {
+			t.Errorf("case %v: expected %v, got %v", i, c.err, err)
+		}
+		if runs != c.runs {
+			t.Errorf("case %v: expected %v runs, got %v", i, c.runs, runs)
+		}
+	}
+}
+
+func TestSupervisorStop(t *testing.T) {
+	s := &supervisor{
+		newExec: crashed,
+		policy:  app.RestartPolicy{Enabled: true},
+		cur:     exitExec{newMockExec(), 1, ""},
+		stopped: make(chan struct{}),
+	}
+	runs := 0
+	err := s.run(func(exec) error {
+		runs++
+		if runs == 2 {
+			s.stop(syscall.SIGTERM)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if runs != 2 {
+		t.Errorf("expected 2 runs, got %v", runs)
+	}
+}
+
+func TestSupervisorStopBeforeStart(t *testing.T) {
+	s := once(newMockExec())
+	s.stop(syscall.SIGTERM)
+	runs := 0
+	err := s.run(func(exec) error {
+		runs++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if runs != 0 {
+		t.Errorf("expected 0 runs, got %v", runs)
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	in := make(chan int)
+	p := relayPeriods(in)
+
+	first := p.next()
+	in <- 5
+	if got := <-first; got != 5 {
+		t.Errorf("expected 5, got %v", got)
+	}
+
+	// A new requester inherits the most recent period.
+	second := p.next()
+	if got := <-second; got != 5 {
+		t.Errorf("expected 5, got %v", got)
+	}
+
+	// Requesters that are running at the same time all get new periods,
+	// until they are released.
+	p.release(first)
+	in <- 7
+	if got := <-second; got != 7 {
+		t.Errorf("expected 7, got %v", got)
+	}
+	select {
+	case got := <-first:
+		t.Errorf("expected nothing for released requester, got %v", got)
+	default:
+	}
+
+	// The zero periods relays nothing.
+	var none periods
+	if c := none.next(); c != nil {
+		t.Errorf("expected nil channel, got %v", c)
+	}
+	none.release(nil)
+}
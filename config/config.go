@@ -1,8 +1,30 @@
 // Package config provides Auklet client configuration data.
+//
+// Every setting has a name, which is used both as a command-line flag and as
+// a key in the config file, and a corresponding environment variable formed by
+// upper-casing the name, replacing dashes with underscores and adding the
+// prefix AUKLET_. For example, the setting base-url can be given as
+// --base-url, as AUKLET_BASE_URL, or as "base-url" in the config file.
+// The exception is api-key, which is not a command-line flag, so that it does
+// not appear in the arguments of the process.
+//
+// When a setting is given in more than one place, the command line takes
+// precedence over the environment, which takes precedence over the config
+// file, which takes precedence over the built-in default.
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/aukletio/Auklet-Client-C/errorlog"
 )
@@ -10,6 +32,9 @@ import (
 // Production defines the base URL for the production environment.
 const Production = "https://api.auklet.io"
 
+// DefaultPath is where the config file is read from if no other path is given.
+const DefaultPath = "/etc/auklet/client.json"
+
 // Getenv is a function to retrieve the values of environment variables.
 type Getenv func(string) string
 
@@ -19,46 +44,442 @@ var OS Getenv = os.Getenv
 // prefix is the prefix used by all Auklet environment variables.
 const prefix = "AUKLET_"
 
-func (getenv Getenv) envar(s string) string {
-	k := getenv(prefix + s)
-	if k == "" {
-		errorlog.Print("warning: empty ", prefix+s)
+// Path returns the path of the config file, as dependent on the CLI args or
+// env vars. explicit reports whether the path was given by the user, in which
+// case the file must exist.
+func (getenv Getenv) Path(fromcli string) (path string, explicit bool) {
+	if fromcli != "" {
+		return fromcli, true
+	}
+	if p := getenv(prefix + "CONFIG"); p != "" {
+		return p, true
 	}
-	return k
+	return DefaultPath, false
 }
 
-// APIKey returns the API granted to the customer upon onboarding.
-// It is used in most API calls, such as requesting SSL certs and getting and
-// posting a device.
-func (getenv Getenv) APIKey() string {
-	return getenv.envar("API_KEY")
+// Source identifies where the value of a setting came from.
+type Source string
+
+// These are the possible sources of a setting.
+const (
+	Default     Source = "default"
+	File        Source = "file"
+	Environment Source = "environment"
+	CommandLine Source = "command line"
+)
+
+// Config holds the client's settings.
+type Config struct {
+	// APIKey is granted to the customer upon onboarding. It is used in
+	// most API calls, such as requesting SSL certs and getting and posting
+	// a device.
+	APIKey string
+
+	// AppID identifies a customer's application as a whole, but not a
+	// particular release of it. It is used in API calls relating to
+	// devices and in profile data sent to broker.
+	AppID string
+
+	// BaseURL is the Auklet API URL.
+	BaseURL string
+
+	LogInfo   bool // whether to log info
+	LogErrors bool // whether to log errors
+
+	UserVersion string // user-defined version string
+	SerialOut   string // address of serial device to write JSON
+	NoNetwork   bool   // disable network communication
+
+	// Sink is a comma-separated list of destinations for messages. If
+	// empty, it is derived from SerialOut and NoNetwork.
+	Sink            string
+	SinkFileMaxSize int64 // size in bytes at which file sinks are rotated
+	SinkFileBackups int   // number of rotated files kept by file sinks
+
+	SerialBaud          int           // bits per second of serial sinks
+	SerialParity        string        // "none", "even" or "odd"
+	SerialStopBits      int           // 1 or 2
+	SerialFlowControl   string        // "none", "rtscts" or "xonxoff"
+	SerialAckTimeout    time.Duration // time to wait for each acknowledgement
+	SerialRetries       int           // retransmissions of unacknowledged frames
+	SerialRetryInterval time.Duration // time between attempts to send stored messages
+	SerialQueueLimit    int64         // bytes of unacknowledged messages to store
+
+	// DataDir is the directory in which the .auklet directory is
+	// created. If empty, the working directory and $HOME are tried in
+	// turn.
+	DataDir string
+
+	// PollPeriod is how often the backend is polled for data-limiting
+	// parameters.
+	PollPeriod time.Duration
+
+	// Encoding is the serialization encoding of broker messages; either
+	// "msgpack" or "json".
+	Encoding string
+
+	Restart           bool          // restart the app after a crash
+	MaxRestarts       int           // maximum number of restarts; 0 means no limit
+	RestartBackoff    time.Duration // delay before the first restart
+	RestartBackoffMax time.Duration // maximum delay between restarts
+
+	IgnoreExitStatus bool          // exit 0 instead of the app's exit status
+	DrainTimeout     time.Duration // time allowed to drain after a signal
+
+	// HandshakeTimeout is how long to wait for an app's agent to identify
+	// itself. Zero means no limit.
+	HandshakeTimeout time.Duration
+
+	// HangWindow is how long an agent may take to answer an emission
+	// request before its app is reported as hung. Zero disables hang
+	// detection.
+	HangWindow time.Duration
+	HangKill   bool // kill hung apps, so that they may be restarted
+
+	// PerfSamplePeriod is how often the resource usage of an app's
+	// process is sampled for trends; zero disables sampling. The trends
+	// looked for are memory growth of more than LeakGrowth percent per
+	// hour over LeakWindow, and CPU usage above CPURunaway percent of
+	// one core for CPURunawayDuration. A zero threshold disables its
+	// check.
+	PerfSamplePeriod   time.Duration
+	LeakGrowth         float64
+	LeakWindow         time.Duration
+	CPURunaway         float64
+	CPURunawayDuration time.Duration
+
+	AgentMaxMessageSize int // bytes; larger agent messages are skipped
+	AgentBuffer         int // agent messages held while the client is busy
+
+	// SampleRate is the profiling rate, in Hz, requested from agents that
+	// accept the sample-rate command. Zero leaves the agent's own rate.
+	SampleRate int
+
+	// AppLogLevel is the least severe level of the application log
+	// entries that are sent; one of the syslog severities, from
+	// "emergency" to "debug".
+	AppLogLevel string
+
+	// CaptureOutput causes the standard output and error of apps to be
+	// sent as log entries, as well as written to the client's own.
+	CaptureOutput   bool
+	OutputRateLimit int // entries per second from each stream; 0 means no limit
+
+	// Breadcrumbs and BreadcrumbBytes bound the number and total message
+	// size of the last log entries attached to the event sent when an
+	// app exits. Zero attaches none.
+	Breadcrumbs     int
+	BreadcrumbBytes int
+
+	// Record is the path of a file to which the streams read from the app
+	// are appended, for later replay. If empty, nothing is recorded.
+	Record string
+
+	// Manifest is the path of a file listing apps to run in place of an
+	// app given on the command line. If empty, no manifest is used.
+	Manifest string
+
+	flags   *flag.FlagSet
+	hidden  *flag.FlagSet // settings that are not command-line flags
+	sources map[string]Source
+	names   []string // names of the settings, in registration order
 }
 
-// AppID identifies a customer's application as a whole, but not a particular
-// release of it. It is used in API calls relating to devices and in profile
-// data sent to broker.
-func (getenv Getenv) AppID() string {
-	return getenv.envar("APP_ID")
+// New returns a Config holding default values, and registers its settings as
+// flags in flags. After the flags are parsed, Load must be called to
+// complete the Config.
+func New(flags *flag.FlagSet) *Config {
+	c := &Config{
+		flags:   flags,
+		hidden:  flag.NewFlagSet("", flag.ContinueOnError),
+		sources: make(map[string]Source),
+	}
+	before := make(map[string]bool)
+	flags.VisitAll(func(f *flag.Flag) { before[f.Name] = true })
+
+	// The API key is not a flag, since the command line of a process can
+	// be read by every user.
+	c.hidden.StringVar(&c.APIKey, "api-key", "", "Auklet API key")
+	c.names = append(c.names, "api-key")
+	c.sources["api-key"] = Default
+
+	flags.StringVar(&c.AppID, "app-id", "", "Auklet application ID")
+	flags.StringVar(&c.BaseURL, "base-url", Production, "Auklet API URL; do not change unless instructed by support")
+	flags.BoolVar(&c.LogInfo, "log-info", false, "log info")
+	flags.BoolVar(&c.LogErrors, "log-errors", false, "log errors")
+	flags.StringVar(&c.UserVersion, "version", "", "user-defined version string")
+	flags.StringVar(&c.SerialOut, "serial-out", "", "address of serial device to write JSON")
+	flags.BoolVar(&c.NoNetwork, "no-network", false, "disable network communication")
+	flags.StringVar(&c.Sink, "sink", "", "comma-separated destinations for messages: mqtt, stdout, file:<path>, serial:<path> or an http(s) URL (default mqtt, or stdout with no-network, or serial:<serial-out>)")
+	flags.Int64Var(&c.SinkFileMaxSize, "sink-file-max-size", 10<<20, "size in bytes at which file sinks are rotated; 0 means never")
+	flags.IntVar(&c.SinkFileBackups, "sink-file-backups", 3, "number of rotated files kept by file sinks")
+	flags.IntVar(&c.SerialBaud, "serial-baud", 115200, "baud rate of serial sinks")
+	flags.StringVar(&c.SerialParity, "serial-parity", "none", `parity of serial sinks, "none", "even" or "odd"`)
+	flags.IntVar(&c.SerialStopBits, "serial-stop-bits", 1, "stop bits of serial sinks, 1 or 2")
+	flags.StringVar(&c.SerialFlowControl, "serial-flow-control", "none", `flow control of serial sinks, "none", "rtscts" or "xonxoff"`)
+	flags.DurationVar(&c.SerialAckTimeout, "serial-ack-timeout", time.Second, "time to wait for the receiver to acknowledge a message")
+	flags.IntVar(&c.SerialRetries, "serial-retries", 3, "retransmissions of an unacknowledged message before it is left for later")
+	flags.DurationVar(&c.SerialRetryInterval, "serial-retry-interval", 30*time.Second, "time between attempts to send stored messages after a failure")
+	flags.Int64Var(&c.SerialQueueLimit, "serial-queue-limit", 10<<20, "bytes of unacknowledged messages to store for serial sinks")
+	flags.StringVar(&c.DataDir, "data-dir", "", "directory in which to store client data (default working directory or $HOME)")
+	flags.DurationVar(&c.PollPeriod, "poll-period", time.Hour, "how often to poll the backend for data-limiting parameters")
+	flags.StringVar(&c.Encoding, "encoding", "msgpack", `encoding of broker messages, "msgpack" or "json"; do not change unless instructed by support`)
+	flags.BoolVar(&c.Restart, "restart", false, "restart the app after a crash")
+	flags.IntVar(&c.MaxRestarts, "max-restarts", 0, "maximum number of restarts; 0 means no limit")
+	flags.DurationVar(&c.RestartBackoff, "restart-backoff", time.Second, "delay before the first restart; doubles with each restart")
+	flags.DurationVar(&c.RestartBackoffMax, "restart-backoff-max", time.Minute, "maximum delay between restarts")
+	flags.BoolVar(&c.IgnoreExitStatus, "ignore-exit-status", false, "exit with status 0 instead of the app's exit status")
+	flags.DurationVar(&c.DrainTimeout, "drain-timeout", 10*time.Second, "time allowed to send or store pending messages after a termination signal")
+	flags.DurationVar(&c.HandshakeTimeout, "handshake-timeout", 10*time.Second, "time to wait for the app's agent to send its version before running the app unmonitored; 0 means no limit")
+	flags.DurationVar(&c.HangWindow, "hang-window", 0, "time the app's agent may take to answer a profile request before the app is reported as hung; 0 disables hang detection")
+	flags.BoolVar(&c.HangKill, "hang-kill", false, "kill the app when it is reported as hung, so that it may be restarted")
+	flags.DurationVar(&c.PerfSamplePeriod, "perf-sample-period", 30*time.Second, "how often to sample the app's memory and CPU usage for signs of a leak or runaway; 0 disables sampling")
+	flags.Float64Var(&c.LeakGrowth, "leak-growth", 20, "growth of the app's resident memory, in percent per hour, reported as a leak; 0 disables the check")
+	flags.DurationVar(&c.LeakWindow, "leak-window", time.Hour, "time over which memory growth is measured")
+	flags.Float64Var(&c.CPURunaway, "cpu-runaway", 90, "CPU usage of the app, in percent of one core, reported as a runaway if it lasts; 0 disables the check")
+	flags.DurationVar(&c.CPURunawayDuration, "cpu-runaway-duration", 10*time.Minute, "time for which CPU usage must stay above cpu-runaway to be reported")
+	flags.IntVar(&c.AgentMaxMessageSize, "agent-max-message-size", 4<<20, "size in bytes above which messages from the app's agent are skipped")
+	flags.IntVar(&c.AgentBuffer, "agent-buffer", 64, "number of messages from the app's agent held while the client is busy; profiles beyond it are dropped")
+	flags.IntVar(&c.SampleRate, "sample-rate", 0, "profiling rate in Hz to request from agents that support it; 0 keeps the agent's rate")
+	flags.StringVar(&c.AppLogLevel, "app-log-level", "info", `least severe level of the app's log entries to send: "debug", "info", "notice", "warning", "error", "critical", "alert" or "emergency"`)
+	flags.BoolVar(&c.CaptureOutput, "capture-output", false, "send the app's standard output and error as log entries, as well as passing them through")
+	flags.IntVar(&c.OutputRateLimit, "output-rate-limit", 100, "entries per second sent from each of the app's standard output and error; 0 means no limit")
+	flags.IntVar(&c.Breadcrumbs, "breadcrumbs", 50, "number of the app's last log entries attached to the event sent when it exits; 0 attaches none")
+	flags.IntVar(&c.BreadcrumbBytes, "breadcrumb-bytes", 8<<10, "total size in bytes of the messages of the log entries attached to exit events")
+	flags.StringVar(&c.Record, "record", "", "append the streams read from the app to this file, for the replay command")
+	flags.StringVar(&c.Manifest, "manifest", "", "run the apps listed in this file instead of a single app")
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if !before[f.Name] {
+			c.names = append(c.names, f.Name)
+			c.sources[f.Name] = Default
+		}
+	})
+	return c
 }
 
-// BaseURL returns the base URL, as dependent on the CLI args or env vars.
-func (getenv Getenv) BaseURL(fromcli string) string {
-	if fromcli != "" {
-		return fromcli
+// envar returns the name of the environment variable for the named setting.
+func envar(name string) string {
+	return prefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Load completes c after its flags have been parsed. Settings not given on
+// the command line are taken from the environment, or failing that, from the
+// config file at path. If explicit is false, a missing config file is not an
+// error.
+func (c *Config) Load(getenv Getenv, path string, explicit bool, readFile func(string) ([]byte, error)) error {
+	c.flags.Visit(func(f *flag.Flag) {
+		if _, ok := c.sources[f.Name]; ok {
+			c.sources[f.Name] = CommandLine
+		}
+	})
+
+	file, err := readConfigFile(path, readFile)
+	if err != nil && (explicit || !os.IsNotExist(err)) {
+		return err
+	}
+	for name := range file {
+		if _, ok := c.sources[name]; !ok {
+			return fmt.Errorf("config: unknown setting %q in %v", name, path)
+		}
 	}
-	url := getenv.envar("BASE_URL")
-	if url != "" {
-		return url
+
+	for _, name := range c.names {
+		if c.sources[name] == CommandLine {
+			continue
+		}
+		if v := getenv(envar(name)); v != "" {
+			if isBool(c.lookup(name)) {
+				if _, err := strconv.ParseBool(v); err != nil {
+					// Before the config file, any value
+					// other than "true" meant false.
+					errorlog.Printf("warning: %v=%q is not a boolean; using false", envar(name), v)
+					v = "false"
+				}
+			}
+			if err := c.lookup(name).Value.Set(v); err != nil {
+				return fmt.Errorf("config: invalid value %q for %v: %v", v, envar(name), err)
+			}
+			c.sources[name] = Environment
+			continue
+		}
+		if v, ok := file[name]; ok {
+			if err := c.lookup(name).Value.Set(v); err != nil {
+				return fmt.Errorf("config: invalid value %q for %v in %v: %v", v, name, path, err)
+			}
+			c.sources[name] = File
+		}
+	}
+
+	for _, name := range []string{"api-key", "app-id"} {
+		if c.Value(name) == "" {
+			errorlog.Print("warning: empty ", name)
+		}
 	}
-	return Production
+	return c.validate()
+}
+
+// isBool reports whether f is a boolean flag.
+func isBool(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
 }
 
-// LogErrors returns whether we should log errors.
-func (getenv Getenv) LogErrors() bool {
-	return getenv(prefix+"LOG_ERRORS") == "true"
+// readConfigFile reads the config file at path into a map from setting names
+// to values.
+func readConfigFile(path string, readFile func(string) ([]byte, error)) (map[string]string, error) {
+	b, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("config: could not parse %v: %v", path, err)
+	}
+	file := make(map[string]string)
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			file[k] = v
+		case bool, json.Number:
+			file[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("config: %v in %v must be a string, number or boolean", k, path)
+		}
+	}
+	return file, nil
 }
 
-// LogInfo returns whether we should log info.
-func (getenv Getenv) LogInfo() bool {
-	return getenv(prefix+"LOG_INFO") == "true"
+// validate checks that c's settings are consistent with each other and
+// returns an error if they are not.
+func (c *Config) validate() error {
+	switch c.Encoding {
+	case "msgpack", "json":
+	default:
+		return fmt.Errorf(`config: encoding must be "msgpack" or "json", not %q`, c.Encoding)
+	}
+	switch c.AppLogLevel {
+	case "debug", "info", "notice", "warning", "error", "critical", "alert", "emergency":
+	default:
+		return fmt.Errorf("config: unknown app-log-level %q", c.AppLogLevel)
+	}
+	for name, d := range map[string]time.Duration{
+		"poll-period":           c.PollPeriod,
+		"restart-backoff":       c.RestartBackoff,
+		"restart-backoff-max":   c.RestartBackoffMax,
+		"drain-timeout":         c.DrainTimeout,
+		"handshake-timeout":     c.HandshakeTimeout,
+		"hang-window":           c.HangWindow,
+		"perf-sample-period":    c.PerfSamplePeriod,
+		"leak-window":           c.LeakWindow,
+		"cpu-runaway-duration":  c.CPURunawayDuration,
+		"serial-ack-timeout":    c.SerialAckTimeout,
+		"serial-retry-interval": c.SerialRetryInterval,
+	} {
+		if d < 0 {
+			return fmt.Errorf("config: %v must not be negative", name)
+		}
+	}
+	if c.PollPeriod == 0 {
+		return fmt.Errorf("config: poll-period must be positive")
+	}
+	if c.MaxRestarts < 0 {
+		return fmt.Errorf("config: max-restarts must not be negative")
+	}
+	if c.SampleRate < 0 {
+		return fmt.Errorf("config: sample-rate must not be negative")
+	}
+	if c.LeakGrowth < 0 || c.CPURunaway < 0 {
+		return fmt.Errorf("config: leak-growth and cpu-runaway must not be negative")
+	}
+	if c.OutputRateLimit < 0 {
+		return fmt.Errorf("config: output-rate-limit must not be negative")
+	}
+	if c.Breadcrumbs < 0 || c.BreadcrumbBytes < 0 {
+		return fmt.Errorf("config: breadcrumbs and breadcrumb-bytes must not be negative")
+	}
+	if c.AgentMaxMessageSize <= 0 || c.AgentBuffer <= 0 {
+		return fmt.Errorf("config: agent-max-message-size and agent-buffer must be positive")
+	}
+	if c.SinkFileMaxSize < 0 || c.SinkFileBackups < 0 {
+		return fmt.Errorf("config: sink-file-max-size and sink-file-backups must not be negative")
+	}
+	if c.SerialRetries < 0 || c.SerialQueueLimit < 0 {
+		return fmt.Errorf("config: serial-retries and serial-queue-limit must not be negative")
+	}
+	if c.SerialRetryInterval == 0 {
+		return fmt.Errorf("config: serial-retry-interval must be positive")
+	}
+	if c.Manifest != "" && c.Record != "" {
+		return fmt.Errorf("config: record cannot be used with manifest")
+	}
+	return nil
+}
+
+// Sinks returns the destinations for messages, as given by Sink or derived
+// from SerialOut and NoNetwork.
+func (c *Config) Sinks() []string {
+	switch {
+	case c.Sink != "":
+		var sinks []string
+		for _, s := range strings.Split(c.Sink, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				sinks = append(sinks, s)
+			}
+		}
+		return sinks
+	case c.SerialOut != "":
+		return []string{"serial:" + c.SerialOut}
+	case c.NoNetwork:
+		return []string{"stdout"}
+	default:
+		return []string{"mqtt"}
+	}
+}
+
+// Value returns the value of the named setting as a string.
+func (c *Config) Value(name string) string {
+	f := c.lookup(name)
+	if f == nil {
+		return ""
+	}
+	return f.Value.String()
+}
+
+// lookup returns the flag of the named setting, or nil if there is none.
+func (c *Config) lookup(name string) *flag.Flag {
+	if f := c.hidden.Lookup(name); f != nil {
+		return f
+	}
+	return c.flags.Lookup(name)
+}
+
+// Source returns where the value of the named setting came from.
+func (c *Config) Source(name string) Source {
+	return c.sources[name]
+}
+
+// secrets are settings whose values are not printed in full.
+var secrets = map[string]bool{"api-key": true}
+
+// Print writes the effective value and source of each setting to w.
+func (c *Config) Print(w io.Writer) error {
+	names := append([]string(nil), c.names...)
+	sort.Strings(names)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, name := range names {
+		v := c.Value(name)
+		if secrets[name] && len(v) > 4 {
+			v = strings.Repeat("*", len(v)-4) + v[len(v)-4:]
+		}
+		src := string(c.sources[name])
+		if c.sources[name] == Environment {
+			src += " (" + envar(name) + ")"
+		}
+		fmt.Fprintf(tw, "%v\t%q\t%v\n", name, v, src)
+	}
+	return tw.Flush()
 }
@@ -1,41 +1,228 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
+func noFile(string) ([]byte, error) { return nil, os.ErrNotExist }
+
+func file(contents string) func(string) ([]byte, error) {
+	return func(string) ([]byte, error) { return []byte(contents), nil }
+}
+
+func load(args []string, getenv Getenv, readFile func(string) ([]byte, error)) (*Config, error) {
+	flags := flag.NewFlagSet("", flag.ContinueOnError)
+	c := New(flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	return c, c.Load(getenv, "client.json", false, readFile)
+}
+
 func TestBaseURL(t *testing.T) {
 	baseURL := "http://example.com"
 	fromCliBaseURL := "http://other.example.com"
 	empty := func(string) string { return "" }
-	nonempty := func(string) string { return baseURL }
+	nonempty := func(k string) string {
+		if k == "AUKLET_BASE_URL" {
+			return baseURL
+		}
+		return ""
+	}
 
 	cases := []struct {
-		baseURL string
-		getenv  Getenv
-		expect  string
+		args   []string
+		getenv Getenv
+		expect string
 	}{
 		{
-			baseURL: fromCliBaseURL,
-			getenv:  nonempty,
-			expect:  fromCliBaseURL,
+			args:   []string{"-base-url", fromCliBaseURL},
+			getenv: nonempty,
+			expect: fromCliBaseURL,
 		},
 		{
-			baseURL: "",
-			getenv:  nonempty,
-			expect:  baseURL,
+			args:   nil,
+			getenv: nonempty,
+			expect: baseURL,
 		},
 		{
-			baseURL: "",
-			getenv:  empty,
-			expect:  Production,
+			args:   nil,
+			getenv: empty,
+			expect: Production,
 		},
 	}
 
 	for i, c := range cases {
-		got := c.getenv.BaseURL(c.baseURL)
-		if got != c.expect {
+		cfg, err := load(c.args, c.getenv, noFile)
+		if err != nil {
+			t.Errorf("case %v: %v", i, err)
+			continue
+		}
+		if got := cfg.BaseURL; got != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	getenv := func(k string) string {
+		return map[string]string{
+			"AUKLET_APP_ID":      "env-app",
+			"AUKLET_POLL_PERIOD": "5m",
+		}[k]
+	}
+	readFile := file(`{
+		"app-id": "file-app",
+		"api-key": "file-key",
+		"poll-period": "10m",
+		"restart": true,
+		"max-restarts": 3
+	}`)
+
+	cfg, err := load([]string{"-poll-period", "1m"}, getenv, readFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		value  interface{}
+		got    interface{}
+		source Source
+	}{
+		{"poll-period", time.Minute, cfg.PollPeriod, CommandLine},
+		{"app-id", "env-app", cfg.AppID, Environment},
+		{"api-key", "file-key", cfg.APIKey, File},
+		{"restart", true, cfg.Restart, File},
+		{"max-restarts", 3, cfg.MaxRestarts, File},
+		{"base-url", Production, cfg.BaseURL, Default},
+	}
+	for _, c := range cases {
+		if c.got != c.value {
+			t.Errorf("%v: expected %v, got %v", c.name, c.value, c.got)
+		}
+		if src := cfg.Source(c.name); src != c.source {
+			t.Errorf("%v: expected source %v, got %v", c.name, c.source, src)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	errRead := errors.New("permission denied")
+	empty := func(string) string { return "" }
+	cases := []struct {
+		args     []string
+		getenv   Getenv
+		readFile func(string) ([]byte, error)
+	}{
+		{readFile: file(`{"no-such-setting": 1}`)},
+		{readFile: file(`{"restart": "maybe"}`)},
+		{readFile: file(`{"restart": [true]}`)},
+		{readFile: file(`not json`)},
+		{readFile: func(string) ([]byte, error) { return nil, errRead }},
+		{args: []string{"-encoding", "xml"}},
+		{args: []string{"-app-log-level", "loud"}},
+		{args: []string{"-poll-period", "0s"}},
+		{args: []string{"-max-restarts", "-1"}},
+		{args: []string{"-sink-file-backups", "-1"}},
+		{args: []string{"-sample-rate", "-1"}},
+		{args: []string{"-output-rate-limit", "-1"}},
+		{args: []string{"-breadcrumb-bytes", "-1"}},
+		{args: []string{"-leak-growth", "-5"}},
+		{args: []string{"-perf-sample-period", "-1s"}},
+		{args: []string{"-agent-buffer", "0"}},
+		{args: []string{"-api-key", "k"}}, // not a flag
+	}
+
+	for i, c := range cases {
+		if c.getenv == nil {
+			c.getenv = empty
+		}
+		if c.readFile == nil {
+			c.readFile = noFile
+		}
+		if _, err := load(c.args, c.getenv, c.readFile); err == nil {
+			t.Errorf("case %v: expected error, got nil", i)
+		}
+	}
+}
+
+func TestEnvBool(t *testing.T) {
+	getenv := func(k string) string {
+		return map[string]string{
+			"AUKLET_LOG_INFO":   "yes please",
+			"AUKLET_LOG_ERRORS": "true",
+		}[k]
+	}
+	cfg, err := load(nil, getenv, noFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogInfo {
+		t.Errorf("log-info: expected false, got %v", cfg.LogInfo)
+	}
+	if !cfg.LogErrors {
+		t.Errorf("log-errors: expected true, got %v", cfg.LogErrors)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	empty := func(string) string { return "" }
+	cases := []struct {
+		args   []string
+		expect string
+	}{
+		{args: nil, expect: "mqtt"},
+		{args: []string{"-no-network"}, expect: "stdout"},
+		{args: []string{"-serial-out", "/dev/ttyS0"}, expect: "serial:/dev/ttyS0"},
+		{args: []string{"-serial-out", "/dev/ttyS0", "-sink", "mqtt, file:out.jsonl,"}, expect: "mqtt file:out.jsonl"},
+	}
+	for i, c := range cases {
+		cfg, err := load(c.args, empty, noFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(cfg.Sinks(), " "); got != c.expect {
 			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
 		}
 	}
 }
+
+func TestMissingExplicitFile(t *testing.T) {
+	flags := flag.NewFlagSet("", flag.ContinueOnError)
+	c := New(flags)
+	empty := func(string) string { return "" }
+	if err := c.Load(empty, "client.json", true, noFile); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestPrint(t *testing.T) {
+	getenv := func(k string) string {
+		if k == "AUKLET_API_KEY" {
+			return "secretkey1234"
+		}
+		return ""
+	}
+	cfg, err := load(nil, getenv, noFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "secretkey") {
+		t.Errorf("api key not masked:\n%v", out)
+	}
+	if !strings.Contains(out, "environment (AUKLET_API_KEY)") {
+		t.Errorf("source not printed:\n%v", out)
+	}
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// App is an app listed in a manifest. Settings that its entry leaves out are
+// taken from the Config.
+type App struct {
+	// Name identifies the app in messages and logs. It defaults to the
+	// base name of the executable.
+	Name string
+
+	Command []string // executable and arguments
+	Env     []string // "KEY=value" pairs added to the client's environment
+
+	UserVersion string
+	AppID       string
+
+	Restart           bool
+	MaxRestarts       int
+	RestartBackoff    time.Duration
+	RestartBackoffMax time.Duration
+}
+
+// manifestApp is an entry of a manifest file. Pointers are nil for settings
+// that are left out.
+type manifestApp struct {
+	Name              string            `json:"name"`
+	Command           []string          `json:"command"`
+	Env               map[string]string `json:"env"`
+	Version           *string           `json:"version"`
+	AppID             *string           `json:"app-id"`
+	Restart           *bool             `json:"restart"`
+	MaxRestarts       *int              `json:"max-restarts"`
+	RestartBackoff    *string           `json:"restart-backoff"`
+	RestartBackoffMax *string           `json:"restart-backoff-max"`
+}
+
+// Apps reads the manifest named by the manifest setting, and returns the
+// apps it lists.
+func (c *Config) Apps(readFile func(string) ([]byte, error)) ([]App, error) {
+	b, err := readFile(c.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	var m struct {
+		Apps []manifestApp `json:"apps"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("config: could not parse %v: %v", c.Manifest, err)
+	}
+	if len(m.Apps) == 0 {
+		return nil, fmt.Errorf("config: %v lists no apps", c.Manifest)
+	}
+
+	apps := make([]App, 0, len(m.Apps))
+	names := make(map[string]bool)
+	for i, e := range m.Apps {
+		a, err := c.app(e)
+		if err != nil {
+			return nil, fmt.Errorf("config: app %v in %v: %v", i+1, c.Manifest, err)
+		}
+		if names[a.Name] {
+			return nil, fmt.Errorf("config: %v lists more than one app named %q", c.Manifest, a.Name)
+		}
+		names[a.Name] = true
+		apps = append(apps, a)
+	}
+	return apps, nil
+}
+
+// app completes e with the settings of c.
+func (c *Config) app(e manifestApp) (App, error) {
+	if len(e.Command) == 0 || e.Command[0] == "" {
+		return App{}, fmt.Errorf("command must not be empty")
+	}
+	a := App{
+		Name:              e.Name,
+		Command:           e.Command,
+		UserVersion:       c.UserVersion,
+		AppID:             c.AppID,
+		Restart:           c.Restart,
+		MaxRestarts:       c.MaxRestarts,
+		RestartBackoff:    c.RestartBackoff,
+		RestartBackoffMax: c.RestartBackoffMax,
+	}
+	if a.Name == "" {
+		a.Name = filepath.Base(e.Command[0])
+	}
+	for k, v := range e.Env {
+		a.Env = append(a.Env, k+"="+v)
+	}
+	sort.Strings(a.Env)
+	if e.Version != nil {
+		a.UserVersion = *e.Version
+	}
+	if e.AppID != nil {
+		a.AppID = *e.AppID
+	}
+	if e.Restart != nil {
+		a.Restart = *e.Restart
+	}
+	if e.MaxRestarts != nil {
+		if *e.MaxRestarts < 0 {
+			return App{}, fmt.Errorf("max-restarts must not be negative")
+		}
+		a.MaxRestarts = *e.MaxRestarts
+	}
+	for _, d := range []struct {
+		name string
+		in   *string
+		out  *time.Duration
+	}{
+		{"restart-backoff", e.RestartBackoff, &a.RestartBackoff},
+		{"restart-backoff-max", e.RestartBackoffMax, &a.RestartBackoffMax},
+	} {
+		if d.in == nil {
+			continue
+		}
+		v, err := time.ParseDuration(*d.in)
+		if err != nil {
+			return App{}, fmt.Errorf("invalid %v: %v", d.name, err)
+		}
+		if v < 0 {
+			return App{}, fmt.Errorf("%v must not be negative", d.name)
+		}
+		*d.out = v
+	}
+	return a, nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApps(t *testing.T) {
+	c, err := load([]string{"-version", "1.0", "-app-id", "fleet", "-restart"}, func(string) string { return "" }, noFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Manifest = "apps.json"
+
+	cases := []struct {
+		manifest string
+		expect   []App
+		ok       bool
+	}{
+		{
+			manifest: `{"apps": [
+				{"command": ["/usr/bin/sensord", "-v"]},
+				{"name": "gps", "command": ["/usr/bin/gpsd"], "env": {"B": "2", "A": "1"},
+				 "version": "2.0", "app-id": "gps", "restart": false, "max-restarts": 3,
+				 "restart-backoff": "5s", "restart-backoff-max": "10m"}
+			]}`,
+			expect: []App{
+				{
+					Name:              "sensord",
+					Command:           []string{"/usr/bin/sensord", "-v"},
+					UserVersion:       "1.0",
+					AppID:             "fleet",
+					Restart:           true,
+					RestartBackoff:    time.Second,
+					RestartBackoffMax: time.Minute,
+				},
+				{
+					Name:              "gps",
+					Command:           []string{"/usr/bin/gpsd"},
+					Env:               []string{"A=1", "B=2"},
+					UserVersion:       "2.0",
+					AppID:             "gps",
+					Restart:           false,
+					MaxRestarts:       3,
+					RestartBackoff:    5 * time.Second,
+					RestartBackoffMax: 10 * time.Minute,
+				},
+			},
+			ok: true,
+		},
+		{manifest: `{"apps": []}`},
+		{manifest: `{"apps": [{"command": []}]}`},
+		{manifest: `{"apps": [{"command": ["a"], "colour": "red"}]}`},
+		{manifest: `{"apps": [{"command": ["a"]}, {"command": ["/bin/a"]}]}`},
+		{manifest: `{"apps": [{"command": ["a"], "restart-backoff": "soon"}]}`},
+		{manifest: `{"apps": [{"command": ["a"], "max-restarts": -1}]}`},
+	}
+	for i, c2 := range cases {
+		apps, err := c.Apps(file(c2.manifest))
+		if ok := err == nil; ok != c2.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c2.ok, ok, err)
+			continue
+		}
+		if c2.ok && !reflect.DeepEqual(apps, c2.expect) {
+			t.Errorf("case %v: expected %+v, got %+v", i, c2.expect, apps)
+		}
+	}
+
+	// A manifest runs several apps, whose streams cannot share a
+	// recording.
+	if _, err := load([]string{"-manifest", "apps.json", "-record", "run.rec"}, func(string) string { return "" }, noFile); err == nil {
+		t.Error("expected error combining manifest and record")
+	}
+}
@@ -1,6 +1,7 @@
 package device
 
 import (
+	"os"
 	"testing"
 	"time"
 )
@@ -13,3 +14,16 @@ func TestMonitor(t *testing.T) {
 	m.GetMetrics()
 	m.Close()
 }
+
+func TestReadProcessMetrics(t *testing.T) {
+	m, err := ReadProcessMetrics(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Threads < 1 || m.RSS == 0 || m.VSize < m.RSS || m.OpenFiles < 3 {
+		t.Errorf("unlikely metrics %+v", m)
+	}
+	if _, err := ReadProcessMetrics(-1); err == nil {
+		t.Error("expected error for a nonexistent process")
+	}
+}
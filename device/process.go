@@ -0,0 +1,98 @@
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProcessMetrics represents the resource usage of a single process, as read
+// from /proc.
+type ProcessMetrics struct {
+	UserTime   int64  `json:"cpuUserMs"`   // CPU time spent in user mode
+	SystemTime int64  `json:"cpuSystemMs"` // CPU time spent in the kernel
+	RSS        uint64 `json:"rssBytes"`    // resident set size
+	VSize      uint64 `json:"virtualBytes"`
+	Threads    int    `json:"threads"`
+	OpenFiles  int    `json:"openFiles"`
+
+	VoluntarySwitches   uint64 `json:"voluntaryContextSwitches"`
+	InvoluntarySwitches uint64 `json:"involuntaryContextSwitches"`
+}
+
+// Rusage represents the resources used by a process over its life, as
+// reported when it exits.
+type Rusage struct {
+	UserTime            int64  `json:"cpuUserMs"`
+	SystemTime          int64  `json:"cpuSystemMs"`
+	MaxRSS              uint64 `json:"maxRssBytes"`
+	MinorFaults         uint64 `json:"minorFaults"`
+	MajorFaults         uint64 `json:"majorFaults"`
+	InBlocks            uint64 `json:"inBlocks"`
+	OutBlocks           uint64 `json:"outBlocks"`
+	VoluntarySwitches   uint64 `json:"voluntaryContextSwitches"`
+	InvoluntarySwitches uint64 `json:"involuntaryContextSwitches"`
+}
+
+// clockTicks is the number of clock ticks per second in which /proc gives CPU
+// times. It is USER_HZ, which the kernel fixes at 100 for user space.
+const clockTicks = 100
+
+// ReadProcessMetrics returns the resource usage of the process whose pid is
+// pid.
+func ReadProcessMetrics(pid int) (ProcessMetrics, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	b, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return ProcessMetrics{}, err
+	}
+	// The fields follow the name, which is in parentheses, and may itself
+	// contain spaces and parentheses. The first of them is the state,
+	// which is the third field of the file.
+	stat := string(b)
+	j := strings.LastIndexByte(stat, ')')
+	fields := strings.Fields(stat[j+1:])
+	if j < 0 || len(fields) < 22 {
+		return ProcessMetrics{}, fmt.Errorf("malformed %v/stat", dir)
+	}
+	field := func(n int) uint64 {
+		v, _ := strconv.ParseUint(fields[n-3], 10, 64)
+		return v
+	}
+	m := ProcessMetrics{
+		UserTime:   int64(field(14)) * 1000 / clockTicks,
+		SystemTime: int64(field(15)) * 1000 / clockTicks,
+		Threads:    int(field(20)),
+		VSize:      field(23),
+		RSS:        field(24) * uint64(os.Getpagesize()),
+	}
+
+	if f, err := os.Open(filepath.Join(dir, "status")); err == nil {
+		lines := bufio.NewScanner(f)
+		for lines.Scan() {
+			f := strings.Fields(lines.Text())
+			if len(f) != 2 {
+				continue
+			}
+			v, _ := strconv.ParseUint(f[1], 10, 64)
+			switch f[0] {
+			case "voluntary_ctxt_switches:":
+				m.VoluntarySwitches = v
+			case "nonvoluntary_ctxt_switches:":
+				m.InvoluntarySwitches = v
+			}
+		}
+		f.Close()
+	}
+
+	// The client may not be allowed to list the descriptors of the
+	// process, in which case the count is left at zero.
+	if fds, err := ioutil.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		m.OpenFiles = len(fds)
+	}
+	return m, nil
+}
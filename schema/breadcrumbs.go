@@ -0,0 +1,74 @@
+package schema
+
+import "encoding/json"
+
+// BreadcrumbLimits bound the recent log entries, or breadcrumbs, that are
+// attached to the event sent when an app exits.
+type BreadcrumbLimits struct {
+	Count int // most entries kept; 0 keeps none
+	Bytes int // most bytes of messages kept; 0 keeps none
+}
+
+// breadcrumb is a log entry written shortly before an app exited.
+type breadcrumb struct {
+	Time    int64  `json:"timestamp"` // Unix milliseconds, when received
+	Level   string `json:"level"`
+	Stream  string `json:"stream,omitempty"` // "stdout" or "stderr"; empty for the log socket
+	Message string `json:"message"`
+}
+
+// breadcrumbs keeps the most recent log entries of an app, within limits. A
+// nil *breadcrumbs keeps nothing.
+type breadcrumbs struct {
+	lim   BreadcrumbLimits
+	list  []breadcrumb // oldest first
+	bytes int          // of the messages in list
+}
+
+func newBreadcrumbs(lim BreadcrumbLimits) *breadcrumbs {
+	if lim.Count <= 0 || lim.Bytes <= 0 {
+		return nil
+	}
+	return &breadcrumbs{lim: lim}
+}
+
+// add adds the entry encoded in data, the data of an "applog" message,
+// discarding the oldest entries as needed to stay within the limits. A message
+// larger than the byte limit is truncated.
+func (b *breadcrumbs) add(data []byte) {
+	if b == nil {
+		return
+	}
+	var e struct {
+		Level   string `json:"level"`
+		Message string `json:"message"`
+		Fields  struct {
+			Stream string `json:"stream"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return
+	}
+	if len(e.Message) > b.lim.Bytes {
+		e.Message = e.Message[:b.lim.Bytes]
+	}
+	b.list = append(b.list, breadcrumb{
+		Time:    nowMilli(),
+		Level:   e.Level,
+		Stream:  e.Fields.Stream,
+		Message: e.Message,
+	})
+	b.bytes += len(e.Message)
+	for len(b.list) > b.lim.Count || b.bytes > b.lim.Bytes {
+		b.bytes -= len(b.list[0].Message)
+		b.list = b.list[1:]
+	}
+}
+
+// recent returns the entries kept by b, oldest first.
+func (b *breadcrumbs) recent() []breadcrumb {
+	if b == nil || len(b.list) == 0 {
+		return nil
+	}
+	return append([]breadcrumb(nil), b.list...)
+}
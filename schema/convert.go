@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/vmihailenco/msgpack"
 
@@ -15,8 +16,10 @@ import (
 
 // Converter converts a stream of agent.Message to a stream of broker.Message.
 type Converter struct {
-	in  MessageSource
-	out chan broker.Message
+	in     MessageSource
+	out    chan broker.Message
+	crumbs *breadcrumbs // recent log entries
+	diags  *diagnostics // not yet sent
 	Config
 }
 
@@ -52,8 +55,10 @@ type Config struct {
 	Username    string
 	UserVersion string
 	AppID       string
+	AppName     string // identifies the app among those run by a manifest
 	MacHash     string
 	Encoding    Encoding
+	Breadcrumbs BreadcrumbLimits
 }
 
 // Encoding represents the serialization encoding.
@@ -71,6 +76,8 @@ func NewConverter(cfg Config, in ...agent.MessageSource) Converter {
 	c := Converter{
 		in:     agent.Merge(in...),
 		out:    make(chan broker.Message),
+		crumbs: newBreadcrumbs(cfg.Breadcrumbs),
+		diags:  &diagnostics{},
 		Config: cfg,
 	}
 	go c.serve()
@@ -82,41 +89,127 @@ func (c Converter) Output() <-chan broker.Message {
 	return c.out
 }
 
+// exitDelay is how long the conversion of an app's exit is put off, so that
+// its last log entries and diagnostics, which travel on other streams, can be
+// attached.
+const exitDelay = 250 * time.Millisecond
+
+// diagnosticDelay is how long a Diagnostic is held for the app's exit, to
+// which it is attached, before it is sent on its own.
+const diagnosticDelay = time.Second
+
 func (c Converter) serve() {
 	defer close(c.out)
 	defer c.Monitor.Close()
-	for agentMsg := range c.in.Output() {
-		switch agentMsg.Type {
-		case "applog", "log":
-			// Drop these messages for now, because consumers do not handle them.
-			continue
-		}
-
-		brokerMsg := c.convert(agentMsg)
-		if c.Persistor != nil {
-			if err := c.Persistor.CreateMessage(&brokerMsg); err != nil {
-				// Let the backend know we ran out of local storage.
-				c.out <- broker.Message{
-					Error: err.Error(),
-					Topic: broker.Log,
+	var (
+		in    = c.in.Output()
+		held  *agent.Message // an exit, waiting for the last log entries and diagnostics
+		delay <-chan time.Time
+		// diagnostics waiting for the app's exit
+		diagDelay <-chan time.Time
+	)
+	for {
+		select {
+		case agentMsg, ok := <-in:
+			if !ok {
+				if held != nil {
+					c.send(*held)
+				}
+				c.sendDiagnostics()
+				return
+			}
+			switch agentMsg.Type {
+			case "log":
+				// Drop these messages for now, because consumers do not handle them.
+				continue
+			case "applog":
+				c.crumbs.add(agentMsg.Data)
+			case "diagnostic":
+				c.diags.add(agentMsg.Data)
+				if diagDelay == nil {
+					diagDelay = time.After(diagnosticDelay)
 				}
 				continue
+			case "event", "cleanExit":
+				if held == nil {
+					held = &agentMsg
+					delay = time.After(exitDelay)
+					continue
+				}
+			}
+			c.send(agentMsg)
+		case <-delay:
+			c.send(*held)
+			held, delay = nil, nil
+		case <-diagDelay:
+			diagDelay = nil
+			if held == nil {
+				// The app survived, or its exit was sent.
+				c.sendDiagnostics()
 			}
 		}
+	}
+}
+
+// sendDiagnostics sends the Diagnostics held by c on their own, or, if the
+// app's exit has been sent, together in a follow-up to it.
+func (c Converter) sendDiagnostics() {
+	if c.diags.exited() {
+		if diags := c.diags.take(); len(diags) > 0 {
+			data, _ := json.Marshal(diags)
+			c.send(agent.Message{Type: "lateDiagnostics", Data: data})
+		}
+		return
+	}
+	for _, d := range c.diags.take() {
+		data, _ := json.Marshal(d)
+		c.send(agent.Message{Type: "diagnostic", Data: data})
+	}
+}
 
-		c.out <- brokerMsg
+// send converts agentMsg, stores it if c has a Persistor, and sends it.
+func (c Converter) send(agentMsg agent.Message) {
+	brokerMsg := c.convert(agentMsg)
+	if c.Persistor != nil {
+		if err := c.Persistor.CreateMessage(&brokerMsg); err != nil {
+			// Let the backend know we ran out of local storage.
+			c.out <- broker.Message{
+				Error: err.Error(),
+				Topic: broker.Log,
+			}
+			return
+		}
 	}
+
+	c.out <- brokerMsg
 }
 
 func (c Converter) convert(m agent.Message) broker.Message {
 	switch m.Type {
 	case "applog":
-		return c.marshal(c.appLog(m.Data), broker.Event)
+		return c.marshal(c.appLog(m.Data), broker.Log)
 	case "profile":
+		if m.MsgPack != nil {
+			return c.msgpackProfile(m.MsgPack)
+		}
 		return c.marshal(c.profile(m.Data), broker.Profile)
 	case "event":
 		log.Printf("%v exited with error signal", c.App)
 		return c.marshal(c.errorSig(m.Data), broker.Event)
+	case "hang":
+		log.Printf("%v is not responding", c.App)
+		return c.marshal(c.hang(m.Data), broker.Event)
+	case "diagnostic":
+		log.Printf("%v reported an error on standard error", c.App)
+		return c.marshal(c.diagnostic(m.Data), broker.Event)
+	case "lateDiagnostics":
+		log.Printf("%v reported an error on standard error before exiting", c.App)
+		return c.marshal(c.lateDiagnostics(m.Data), broker.Event)
+	case "performance":
+		log.Printf("%v has a performance issue", c.App)
+		return c.marshal(c.performance(m.Data), broker.Event)
+	case "stats":
+		return c.marshal(c.streamStats(m.Data), broker.Log)
 	case "log":
 		return broker.Message{
 			Bytes: m.Data,
@@ -145,6 +238,148 @@ func msgpackMarshal(v interface{}) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
+// msgpackProfile converts a profile whose data is encoded in msgpack. If the
+// output is also msgpack, the profile tree is passed through without being
+// decoded.
+func (c Converter) msgpackProfile(data []byte) broker.Message {
+	if c.Encoding != MsgPack {
+		var v interface{}
+		err := msgpack.Unmarshal(data, &v)
+		if err == nil {
+			data, err = json.Marshal(v)
+		}
+		if err != nil {
+			return broker.Message{Error: err.Error(), Topic: broker.Log}
+		}
+		return c.marshal(c.profile(data), broker.Profile)
+	}
+	meta, err := msgpackMarshal(struct {
+		metadata
+		Process *device.ProcessMetrics `json:"processMetrics,omitempty"`
+	}{c.metadata(), c.processMetrics()})
+	if err == nil {
+		data, err = mergeMaps(meta, data)
+	}
+	if err != nil {
+		return broker.Message{Error: err.Error(), Topic: broker.Log}
+	}
+	return broker.Message{Bytes: data, Topic: broker.Profile}
+}
+
+// mapEntry is an entry of a map encoded in msgpack.
+type mapEntry struct {
+	key string
+	raw []byte // the encoded key and value
+}
+
+// mapEntries returns the entries of b, a map encoded in msgpack, without
+// decoding their values.
+func mapEntries(b []byte) ([]mapEntry, error) {
+	r := bytes.NewReader(b)
+	dec := msgpack.NewDecoder(r)
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return nil, err
+	}
+	var entries []mapEntry
+	for i := 0; i < n; i++ {
+		start := len(b) - r.Len()
+		key, err := dec.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		if err := dec.Skip(); err != nil {
+			return nil, err
+		}
+		entries = append(entries, mapEntry{key: key, raw: b[start : len(b)-r.Len()]})
+	}
+	return entries, nil
+}
+
+// mergeMaps returns a map encoded in msgpack that holds the entries of a and
+// b, which are also maps encoded in msgpack. Entries of b whose keys are in a
+// are left out.
+func mergeMaps(a, b []byte) ([]byte, error) {
+	first, err := mapEntries(a)
+	if err != nil {
+		return nil, err
+	}
+	second, err := mapEntries(b)
+	if err != nil {
+		return nil, err
+	}
+	entries := first
+	inFirst := make(map[string]bool)
+	for _, e := range first {
+		inFirst[e.key] = true
+	}
+	for _, e := range second {
+		if !inFirst[e.key] {
+			entries = append(entries, e)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := msgpack.NewEncoder(&buf).EncodeMapLen(len(entries)); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		buf.Write(e.raw)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a broker message payload produced by a Converter, whatever
+// its encoding, into a generic value that can be marshaled to JSON.
+func Decode(payload []byte) (interface{}, error) {
+	var v interface{}
+	if json.Valid(payload) {
+		err := json.Unmarshal(payload, &v)
+		return v, err
+	}
+	err := msgpack.Unmarshal(payload, &v)
+	return v, err
+}
+
+// FromJSON re-encodes a JSON payload, such as one relayed from another
+// client, in the given encoding.
+func FromJSON(payload []byte, enc Encoding) ([]byte, error) {
+	if enc == JSON {
+		var buf bytes.Buffer
+		err := json.Compact(&buf, payload)
+		return buf.Bytes(), err
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return msgpackMarshal(numbers(v))
+}
+
+// numbers replaces the json.Numbers in v with integers where possible, or
+// floats otherwise, so that they are not encoded as strings.
+func numbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = numbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = numbers(e)
+		}
+	}
+	return v
+}
+
 func (c Converter) marshal(v interface{}, topic broker.Topic) broker.Message {
 	marshaler := map[Encoding]func(interface{}) ([]byte, error){
 		MsgPack: msgpackMarshal,
@@ -1,7 +1,13 @@
 package schema
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack"
 
 	"github.com/aukletio/Auklet-Client-C/agent"
 	"github.com/aukletio/Auklet-Client-C/broker"
@@ -47,6 +53,10 @@ func TestConverter(t *testing.T) {
 		{input: agent.Message{Type: "event"}, ok: true},
 		{input: agent.Message{Type: "profile"}, ok: true},
 		{input: agent.Message{Type: "cleanExit"}, ok: true},
+		{input: agent.Message{Type: "stats", Data: []byte(`{"dropped":3}`)}, ok: true},
+		{input: agent.Message{Type: "hang", Data: []byte(`{"waitedMs":5,"process":{"pid":7}}`)}, ok: true},
+		{input: agent.Message{Type: "applog", Data: []byte(`{"level":"error","message":"x"}`)}, ok: true},
+		{input: agent.Message{Type: "performance", Data: []byte(`{"kind":"memory-leak","samples":[{"rssBytes":1}]}`)}, ok: true},
 		{input: agent.Message{Type: "unknown"}, ok: false},
 	}
 	for i, c := range cases {
@@ -61,3 +71,300 @@ func TestConverter(t *testing.T) {
 		close(s)
 	}
 }
+
+func TestDecode(t *testing.T) {
+	for _, enc := range []Encoding{MsgPack, JSON} {
+		c := Converter{Config: cfg}
+		c.Encoding = enc
+		m := c.marshal(c.exit(), broker.Event)
+		v, err := Decode(m.Bytes)
+		if err != nil {
+			t.Errorf("encoding %v: %v", enc, err)
+			continue
+		}
+		if status := v.(map[string]interface{})["exitStatus"]; fmt.Sprint(status) != "42" {
+			t.Errorf("encoding %v: expected exit status 42, got %v", enc, status)
+		}
+	}
+	if _, err := Decode([]byte{0xc1}); err == nil {
+		t.Error("expected error decoding invalid payload")
+	}
+}
+
+func TestHang(t *testing.T) {
+	for _, enc := range []Encoding{MsgPack, JSON} {
+		c := Converter{Config: cfg}
+		c.Encoding = enc
+		m := c.marshal(c.hang([]byte(`{"waitedMs":5,"process":{"pid":7}}`)), broker.Event)
+		v, err := Decode(m.Bytes)
+		if err != nil {
+			t.Errorf("encoding %v: %v", enc, err)
+			continue
+		}
+		process, _ := v.(map[string]interface{})["process"].(map[string]interface{})
+		if pid := fmt.Sprint(process["pid"]); pid != "7" {
+			t.Errorf("encoding %v: expected pid 7, got %v", enc, pid)
+		}
+	}
+}
+
+func TestAppLog(t *testing.T) {
+	for _, enc := range []Encoding{MsgPack, JSON} {
+		c := Converter{Config: cfg}
+		c.Encoding = enc
+		m := c.convert(agent.Message{
+			Type: "applog",
+			Data: []byte(`{"level":"warning","message":"slow","fields":{"ms":250}}`),
+		})
+		if m.Topic != broker.Log {
+			t.Errorf("encoding %v: expected topic %v, got %v", enc, broker.Log, m.Topic)
+		}
+		v, err := Decode(m.Bytes)
+		if err != nil {
+			t.Errorf("encoding %v: %v", enc, err)
+			continue
+		}
+		l := v.(map[string]interface{})
+		fields, _ := l["fields"].(map[string]interface{})
+		if got := fmt.Sprintf("%v %v %v", l["level"], l["message"], fields["ms"]); got != "warning slow 250" {
+			t.Errorf("encoding %v: expected warning slow 250, got %v", enc, got)
+		}
+	}
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	c := cfg
+	c.Encoding = JSON
+	c.Breadcrumbs = BreadcrumbLimits{Count: 2, Bytes: 10}
+	s := make(source)
+	converter := NewConverter(c, s)
+	entry := func(msg string) agent.Message {
+		return agent.Message{Type: "applog", Data: []byte(`{"level":"info","message":"` + msg + `","fields":{"stream":"stderr"}}`)}
+	}
+	go func() {
+		for _, m := range []agent.Message{
+			entry("one"),
+			entry("two"),
+			entry("three"),
+			{Type: "event", Data: []byte(`{}`)},
+			// An entry that arrives shortly after the exit is attached.
+			entry("last"),
+		} {
+			s <- m
+		}
+		close(s)
+	}()
+
+	var event broker.Message
+	for m := range converter.Output() {
+		if m.Topic == broker.Event {
+			event = m
+		}
+	}
+	v, err := Decode(event.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crumbs, _ := v.(map[string]interface{})["breadcrumbs"].([]interface{})
+	var got []string
+	for _, b := range crumbs {
+		b := b.(map[string]interface{})
+		got = append(got, fmt.Sprint(b["stream"], ":", b["message"]))
+	}
+	if fmt.Sprint(got) != "[stderr:three stderr:last]" {
+		t.Errorf("expected [stderr:three stderr:last], got %v", got)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	diag := agent.Message{Type: "diagnostic", Data: []byte(`{"tool":"assert","kind":"assertion failure"}`)}
+	cases := []struct {
+		input  []agent.Message
+		wait   time.Duration // before the last message
+		expect string        // kind of each event and the tools of its diagnostics
+	}{
+		{
+			// An app that exits has the diagnostic attached.
+			input:  []agent.Message{diag, {Type: "cleanExit"}},
+			expect: "[exit:[assert]]",
+		},
+		{
+			// One that survives has it sent on its own.
+			input:  []agent.Message{diag},
+			expect: "[diagnostic:assert]",
+		},
+		{
+			// One read soon after the exit is attached to it, even without
+			// breadcrumbs.
+			input:  []agent.Message{{Type: "cleanExit"}, diag},
+			expect: "[exit:[assert]]",
+		},
+		{
+			// One read after the exit was sent follows it.
+			input:  []agent.Message{{Type: "cleanExit"}, diag},
+			wait:   2 * exitDelay,
+			expect: "[exit:[] late:[assert]]",
+		},
+	}
+	for i, tc := range cases {
+		c := cfg
+		c.Encoding = JSON
+		s := make(source)
+		converter := NewConverter(c, s)
+		go func() {
+			for i, m := range tc.input {
+				if i == len(tc.input)-1 {
+					time.Sleep(tc.wait)
+				}
+				s <- m
+			}
+			close(s)
+		}()
+		var got []string
+		for m := range converter.Output() {
+			v, err := Decode(m.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := v.(map[string]interface{})
+			if _, ok := e["exitStatus"]; ok {
+				var tools []string
+				diags, _ := e["diagnostics"].([]interface{})
+				for _, d := range diags {
+					tools = append(tools, fmt.Sprint(d.(map[string]interface{})["tool"]))
+				}
+				kind := "exit:"
+				if _, ok := e["exitId"]; ok {
+					kind = "late:"
+				}
+				got = append(got, fmt.Sprint(kind, tools))
+			} else {
+				got = append(got, fmt.Sprint("diagnostic:", e["tool"]))
+			}
+		}
+		if fmt.Sprint(got) != tc.expect {
+			t.Errorf("case %v: expected %v, got %v", i, tc.expect, got)
+		}
+	}
+}
+
+func TestBreadcrumbLimits(t *testing.T) {
+	b := newBreadcrumbs(BreadcrumbLimits{Count: 3, Bytes: 5})
+	for _, msg := range []string{"ab", "cd", "efg", "0123456789"} {
+		b.add([]byte(`{"message":"` + msg + `"}`))
+	}
+	var got []string
+	for _, c := range b.recent() {
+		got = append(got, c.Message)
+	}
+	if fmt.Sprint(got) != "[01234]" {
+		t.Errorf("expected [01234], got %v", got)
+	}
+	if newBreadcrumbs(BreadcrumbLimits{Count: 3}).recent() != nil {
+		t.Error("expected no breadcrumbs with no bytes allowed")
+	}
+}
+
+// process is an app that is the test process, and has exited if ru is not
+// nil.
+type process struct {
+	app
+	ru *device.Rusage
+}
+
+func (process) Pid() int                 { return os.Getpid() }
+func (p process) Rusage() *device.Rusage { return p.ru }
+
+func TestProcessMetrics(t *testing.T) {
+	running := Converter{Config: cfg}
+	running.App = process{}
+	exited := running
+	exited.App = process{ru: &device.Rusage{UserTime: 5}}
+	cases := []struct {
+		msg    broker.Message
+		expect string
+	}{
+		{msg: running.marshal(running.profile([]byte(`{}`)), broker.Profile), expect: "metrics:true rusage:<nil>"},
+		{msg: exited.marshal(exited.exit(), broker.Event), expect: "metrics:false rusage:5"},
+		{msg: exited.marshal(exited.errorSig([]byte(`{}`)), broker.Event), expect: "metrics:false rusage:5"},
+	}
+	for i, c := range cases {
+		v, err := Decode(c.msg.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := v.(map[string]interface{})
+		var ru interface{}
+		if r, ok := m["rusage"].(map[string]interface{}); ok {
+			ru = r["cpuUserMs"]
+		}
+		_, metrics := m["processMetrics"]
+		if got := fmt.Sprintf("metrics:%v rusage:%v", metrics, ru); got != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, got)
+		}
+	}
+}
+
+func TestMsgPackProfile(t *testing.T) {
+	data, err := msgpack.Marshal(map[string]interface{}{
+		"tree":    map[string]interface{}{"nCalls": 3},
+		"release": "the agent's",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, enc := range []Encoding{MsgPack, JSON} {
+		c := Converter{Config: cfg}
+		c.Encoding = enc
+		m := c.msgpackProfile(data)
+		v, err := Decode(m.Bytes)
+		if err != nil {
+			t.Errorf("encoding %v: %v %v", enc, err, m.Error)
+			continue
+		}
+		p := v.(map[string]interface{})
+		tree, _ := p["tree"].(map[string]interface{})
+		if got := fmt.Sprintf("%v %v", tree["nCalls"], p["release"]); got != "3 checksum" {
+			t.Errorf("encoding %v: expected 3 checksum, got %v", enc, got)
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	in := []byte(`{"exitStatus": 42, "load": 0.5, "name": "x"}`)
+	for _, enc := range []Encoding{MsgPack, JSON} {
+		b, err := FromJSON(in, enc)
+		if err != nil {
+			t.Errorf("encoding %v: %v", enc, err)
+			continue
+		}
+		v, err := Decode(b)
+		if err != nil {
+			t.Errorf("encoding %v: %v", enc, err)
+			continue
+		}
+		m := v.(map[string]interface{})
+		if fmt.Sprintf("%v %v %v", m["exitStatus"], m["load"], m["name"]) != "42 0.5 x" {
+			t.Errorf("encoding %v: expected 42 0.5 x, got %v", enc, m)
+		}
+	}
+	if _, err := FromJSON([]byte(`{`), MsgPack); err == nil {
+		t.Error("expected error converting invalid JSON")
+	}
+}
+
+func TestFailure(t *testing.T) {
+	c := cfg
+	c.Encoding = JSON
+	m := Failure(c, errors.New("no agent"))
+	if m.Topic != broker.Log || m.Error != "" {
+		t.Errorf("expected log message, got %+v", m)
+	}
+	v, err := Decode(m.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := v.(map[string]interface{})["message"]; msg != "no agent" {
+		t.Errorf("expected message %q, got %v", "no agent", msg)
+	}
+}
@@ -1,11 +1,14 @@
 package schema
 
 import (
+	"bytes"
 	"encoding/json"
 	"time"
 
 	"github.com/satori/go.uuid"
 
+	"github.com/aukletio/Auklet-Client-C/agent"
+	"github.com/aukletio/Auklet-Client-C/broker"
 	"github.com/aukletio/Auklet-Client-C/device"
 	"github.com/aukletio/Auklet-Client-C/version"
 )
@@ -21,6 +24,30 @@ type metadata struct {
 	UUID          string `json:"id"`        // identifier for this message
 	Time          int64  `json:"timestamp"` // Unix milliseconds
 	Error         string `json:"error,omitempty"`
+	AppName       string `json:"appName,omitempty"` // name given in a manifest
+}
+
+// processMetrics returns the resource usage of the app's process, or nil if
+// the app has no process that can be measured, or it has exited.
+func (c Converter) processMetrics() *device.ProcessMetrics {
+	p, ok := c.App.(interface{ Pid() int })
+	if !ok || p.Pid() == 0 || c.rusage() != nil {
+		return nil
+	}
+	m, err := device.ReadProcessMetrics(p.Pid())
+	if err != nil {
+		return nil
+	}
+	return &m
+}
+
+// rusage returns the resources used by the app's process over its life, or
+// nil if the app has no process or it has not exited.
+func (c Converter) rusage() *device.Rusage {
+	if r, ok := c.App.(interface{ Rusage() *device.Rusage }); ok {
+		return r.Rusage()
+	}
+	return nil
 }
 
 func nowMilli() int64 {
@@ -34,6 +61,7 @@ func (c Converter) metadata() metadata {
 		ClientVersion: version.Version,
 		AgentVersion:  c.App.AgentVersion(),
 		AppID:         c.AppID,
+		AppName:       c.AppName,
 		CheckSum:      c.App.CheckSum(),
 		IP:            device.CurrentIP(),
 		UUID:          uuid.NewV4().String(),
@@ -44,26 +72,75 @@ func (c Converter) metadata() metadata {
 // appLog represents custom log data as expected by broker consumers.
 type appLog struct {
 	metadata
-	// Message is the log message sent by the application.
-	Message []byte         `json:"message"`
-	MacHash string         `json:"macAddressHash"`
-	Metrics device.Metrics `json:"systemMetrics"`
+	// Level and Message are those of the log entry written by the
+	// application, and Fields holds any structured data it included.
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	MacHash string                 `json:"macAddressHash"`
+	Metrics device.Metrics         `json:"systemMetrics"`
+}
+
+func (c Converter) appLog(data []byte) appLog {
+	var l appLog
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&l); err != nil {
+		l.Error = err.Error()
+	}
+	for k, v := range l.Fields {
+		l.Fields[k] = numbers(v)
+	}
+	l.metadata = c.metadata()
+	l.MacHash = c.MacHash
+	l.Metrics = c.Monitor.GetMetrics()
+	return l
 }
 
-func (c Converter) appLog(msg []byte) appLog {
-	return appLog{
+// failure reports a problem that keeps the client from monitoring an app.
+type failure struct {
+	metadata
+	Message string `json:"message"`
+	MacHash string `json:"macAddressHash"`
+}
+
+// Failure returns a log message reporting err, which keeps the client from
+// monitoring cfg.App.
+func Failure(cfg Config, err error) broker.Message {
+	c := Converter{Config: cfg}
+	return c.marshal(failure{
 		metadata: c.metadata(),
+		Message:  err.Error(),
 		MacHash:  c.MacHash,
-		Metrics:  c.Monitor.GetMetrics(),
-		Message:  msg,
+	}, broker.Log)
+}
+
+// streamStats counts the messages from an agent that were lost, as described
+// by agent.Stats.
+type streamStats struct {
+	metadata
+	Oversized int    `json:"oversized"`
+	Malformed int    `json:"malformed"`
+	Dropped   int    `json:"dropped"`
+	MacHash   string `json:"macAddressHash"`
+}
+
+func (c Converter) streamStats(data []byte) streamStats {
+	var s streamStats
+	if err := json.Unmarshal(data, &s); err != nil {
+		s.Error = err.Error()
 	}
+	s.metadata = c.metadata()
+	s.MacHash = c.MacHash
+	return s
 }
 
 // profile represents profile data as expected by broker consumers.
 type profile struct {
 	metadata
 	// Tree represents the profile tree data generated by an agent.
-	Tree node `json:"tree"`
+	Tree    node                   `json:"tree"`
+	Process *device.ProcessMetrics `json:"processMetrics,omitempty"`
 }
 
 type node struct {
@@ -81,6 +158,7 @@ func (c Converter) profile(data []byte) profile {
 		p.Error = err.Error()
 	}
 	p.metadata = c.metadata()
+	p.Process = c.processMetrics()
 	return p
 }
 
@@ -93,6 +171,16 @@ type errorSig struct {
 	Trace   []frame        `json:"stackTrace"`
 	MacHash string         `json:"macAddressHash"`
 	Metrics device.Metrics `json:"systemMetrics"`
+
+	// Process is the resource usage of the app's process as it crashed,
+	// and Rusage is its total once the process exited.
+	Process *device.ProcessMetrics `json:"processMetrics,omitempty"`
+	Rusage  *device.Rusage         `json:"rusage,omitempty"`
+
+	// Breadcrumbs are the last log entries of the app.
+	Breadcrumbs []breadcrumb `json:"breadcrumbs,omitempty"`
+	// Diagnostics are the errors reported on the app's standard error.
+	Diagnostics []agent.Diagnostic `json:"diagnostics,omitempty"`
 }
 
 type frame struct {
@@ -107,12 +195,47 @@ func (c Converter) errorSig(data []byte) errorSig {
 		e.Error = err.Error()
 	}
 	e.metadata = c.metadata()
+	// The process is measured before it is waited for.
+	e.Process = c.processMetrics()
 	e.Status = c.App.ExitStatus()
+	e.Rusage = c.rusage()
 	e.MacHash = c.MacHash
 	e.Metrics = c.Monitor.GetMetrics()
+	e.Breadcrumbs = c.crumbs.recent()
+	e.Diagnostics = c.diags.attach(e.UUID)
 	return e
 }
 
+// hang represents an app whose agent stopped answering emission requests.
+// Its fields are those of agent.Hang, with the details decoded so that they
+// can be encoded in either encoding.
+type hang struct {
+	metadata
+	Waited    int64          `json:"waitedMs"`
+	Process   interface{}    `json:"process,omitempty"`
+	StackDump interface{}    `json:"stackDump,omitempty"`
+	MacHash   string         `json:"macAddressHash"`
+	Metrics   device.Metrics `json:"systemMetrics"`
+
+	ProcessMetrics *device.ProcessMetrics `json:"processMetrics,omitempty"`
+}
+
+func (c Converter) hang(data []byte) hang {
+	var h hang
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&h); err != nil {
+		h.Error = err.Error()
+	}
+	h.Process = numbers(h.Process)
+	h.StackDump = numbers(h.StackDump)
+	h.metadata = c.metadata()
+	h.MacHash = c.MacHash
+	h.Metrics = c.Monitor.GetMetrics()
+	h.ProcessMetrics = c.processMetrics()
+	return h
+}
+
 // exit represents the exit of an app in which an agent did not handle a
 // signal. The app may or may not have been delivered a termination signal of
 // some kind, but not one handled by an agent. See man 7 signal for details.
@@ -122,14 +245,133 @@ type exit struct {
 	Signal  string         `json:"signal"`
 	MacHash string         `json:"macAddressHash"`
 	Metrics device.Metrics `json:"systemMetrics"`
+	Rusage  *device.Rusage `json:"rusage,omitempty"` // of the app's process over its life
+
+	// Breadcrumbs are the last log entries of the app.
+	Breadcrumbs []breadcrumb `json:"breadcrumbs,omitempty"`
+	// Diagnostics are the errors reported on the app's standard error.
+	Diagnostics []agent.Diagnostic `json:"diagnostics,omitempty"`
 }
 
 func (c Converter) exit() exit {
-	return exit{
-		metadata: c.metadata(),
-		Status:   c.App.ExitStatus(),
-		Signal:   c.App.Signal(),
-		MacHash:  c.MacHash,
-		Metrics:  c.Monitor.GetMetrics(),
+	e := exit{
+		metadata:    c.metadata(),
+		Status:      c.App.ExitStatus(),
+		Signal:      c.App.Signal(),
+		Rusage:      c.rusage(),
+		MacHash:     c.MacHash,
+		Metrics:     c.Monitor.GetMetrics(),
+		Breadcrumbs: c.crumbs.recent(),
+	}
+	e.Diagnostics = c.diags.attach(e.UUID)
+	return e
+}
+
+// lateDiagnostics represents errors reported on the standard error of an app
+// that were read after the event of its exit was sent. The output of an app
+// is read apart from its agent, and may end after its exit is seen.
+type lateDiagnostics struct {
+	metadata
+	ExitID      string             `json:"exitId"` // of the event of the app's exit
+	Status      int                `json:"exitStatus"`
+	Signal      string             `json:"signal"`
+	MacHash     string             `json:"macAddressHash"`
+	Metrics     device.Metrics     `json:"systemMetrics"`
+	Diagnostics []agent.Diagnostic `json:"diagnostics"`
+}
+
+func (c Converter) lateDiagnostics(data []byte) lateDiagnostics {
+	var l lateDiagnostics
+	if err := json.Unmarshal(data, &l.Diagnostics); err != nil {
+		l.Error = err.Error()
+	}
+	l.metadata = c.metadata()
+	l.ExitID = c.diags.exitID
+	l.Status = c.App.ExitStatus()
+	l.Signal = c.App.Signal()
+	l.MacHash = c.MacHash
+	l.Metrics = c.Monitor.GetMetrics()
+	return l
+}
+
+// diagnostic represents an error reported by a sanitizer or the C library in
+// an app that kept running. Errors reported by an app that then exits are
+// attached to the exit event instead, or sent in a lateDiagnostics.
+type diagnostic struct {
+	metadata
+	agent.Diagnostic
+	MacHash string         `json:"macAddressHash"`
+	Metrics device.Metrics `json:"systemMetrics"`
+}
+
+func (c Converter) diagnostic(data []byte) diagnostic {
+	var d diagnostic
+	if err := json.Unmarshal(data, &d.Diagnostic); err != nil {
+		d.Error = err.Error()
+	}
+	d.metadata = c.metadata()
+	d.MacHash = c.MacHash
+	d.Metrics = c.Monitor.GetMetrics()
+	return d
+}
+
+// performance represents a trend in the resource usage of an app's process
+// that indicates a problem, such as a memory leak.
+type performance struct {
+	metadata
+	agent.PerfIssue
+	MacHash string                 `json:"macAddressHash"`
+	Metrics device.Metrics         `json:"systemMetrics"`
+	Process *device.ProcessMetrics `json:"processMetrics,omitempty"`
+}
+
+func (c Converter) performance(data []byte) performance {
+	var p performance
+	if err := json.Unmarshal(data, &p.PerfIssue); err != nil {
+		p.Error = err.Error()
+	}
+	p.metadata = c.metadata()
+	p.MacHash = c.MacHash
+	p.Metrics = c.Monitor.GetMetrics()
+	p.Process = c.processMetrics()
+	return p
+}
+
+// diagnostics holds the Diagnostics of an app until they are attached to its
+// exit or sent on their own. A nil *diagnostics holds none.
+type diagnostics struct {
+	list   []agent.Diagnostic
+	exitID string // of the event of the app's exit, once it is converted
+}
+
+// add adds the Diagnostic encoded in data, the data of a "diagnostic"
+// message.
+func (d *diagnostics) add(data []byte) {
+	var diag agent.Diagnostic
+	if err := json.Unmarshal(data, &diag); err == nil {
+		d.list = append(d.list, diag)
+	}
+}
+
+// attach returns the Diagnostics held by d, to be attached to the event of the
+// app's exit, whose ID is exitID, and forgets them.
+func (d *diagnostics) attach(exitID string) []agent.Diagnostic {
+	if d == nil {
+		return nil
+	}
+	d.exitID = exitID
+	return d.take()
+}
+
+// exited reports whether the event of the app's exit has been converted.
+func (d *diagnostics) exited() bool { return d != nil && d.exitID != "" }
+
+// take returns the Diagnostics held by d, and forgets them.
+func (d *diagnostics) take() []agent.Diagnostic {
+	if d == nil {
+		return nil
 	}
+	list := d.list
+	d.list = nil
+	return list
 }
@@ -0,0 +1,152 @@
+// Package serial implements a framed, acknowledged transport for serial
+// lines.
+//
+// A frame is laid out as follows, with integers in big-endian order:
+//
+//	magic    2 bytes  0xA5 0x5A
+//	kind     1 byte   Data or Ack
+//	seq      4 bytes  sequence number
+//	length   4 bytes  length of the payload
+//	payload  length bytes
+//	crc      4 bytes  CRC-32 (IEEE) of kind, seq, length and payload
+//
+// The receiver answers each intact Data frame with an Ack frame that carries
+// the same sequence number and no payload. Corrupt frames are dropped without
+// reply; the sender retransmits a Data frame if no Ack arrives in time.
+package serial
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Kind distinguishes data frames from acknowledgements.
+type Kind byte
+
+// These are the kinds of frames.
+const (
+	Data Kind = 1
+	Ack  Kind = 2
+)
+
+// MaxPayload is the largest payload a frame may carry, in bytes.
+const MaxPayload = 1 << 20
+
+var magic = [2]byte{0xA5, 0x5A}
+
+// headerSize is the size of the fields following magic and preceding the
+// payload.
+const headerSize = 1 + 4 + 4
+
+// Frame is a unit of transmission.
+type Frame struct {
+	Kind    Kind
+	Seq     uint32
+	Payload []byte
+}
+
+// Errors returned by ReadFrame for frames that are dropped. The Reader
+// remains usable after them.
+var (
+	ErrChecksum = errors.New("serial: frame checksum mismatch")
+	ErrTooLarge = errors.New("serial: frame payload too large")
+)
+
+// WriteFrame writes f to w in a single call to w.Write.
+func WriteFrame(w io.Writer, f Frame) error {
+	if len(f.Payload) > MaxPayload {
+		return ErrTooLarge
+	}
+	b := make([]byte, 0, len(magic)+headerSize+len(f.Payload)+4)
+	b = append(b, magic[:]...)
+	b = append(b, byte(f.Kind))
+	b = appendUint32(b, f.Seq)
+	b = appendUint32(b, uint32(len(f.Payload)))
+	b = append(b, f.Payload...)
+	b = appendUint32(b, crc32.ChecksumIEEE(b[len(magic):]))
+	_, err := w.Write(b)
+	return err
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// Reader reads frames from a byte stream.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{bufio.NewReader(r)}
+}
+
+// ReadFrame returns the next frame. Bytes that precede a frame's magic
+// number are skipped.
+func (r *Reader) ReadFrame() (Frame, error) {
+	if err := r.sync(); err != nil {
+		return Frame{}, err
+	}
+
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return Frame{}, err
+	}
+	f := Frame{
+		Kind: Kind(header[0]),
+		Seq:  binary.BigEndian.Uint32(header[1:5]),
+	}
+	n := binary.BigEndian.Uint32(header[5:9])
+	if n > MaxPayload {
+		return Frame{}, ErrTooLarge
+	}
+
+	body := make([]byte, n+4)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return Frame{}, err
+	}
+	f.Payload = body[:n]
+	crc := crc32.NewIEEE()
+	crc.Write(header[:])
+	crc.Write(f.Payload)
+	if crc.Sum32() != binary.BigEndian.Uint32(body[n:]) {
+		return Frame{}, ErrChecksum
+	}
+	return f, nil
+}
+
+// sync consumes bytes up to and including the next magic number.
+func (r *Reader) sync() error {
+	matched := 0
+	for matched < len(magic) {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == magic[matched]:
+			matched++
+		case b == magic[0]:
+			matched = 1
+		default:
+			matched = 0
+		}
+	}
+	return nil
+}
+
+// String returns a description of f.
+func (f Frame) String() string {
+	kind := "data"
+	if f.Kind == Ack {
+		kind = "ack"
+	}
+	return fmt.Sprintf("%v %v (%v bytes)", kind, f.Seq, len(f.Payload))
+}
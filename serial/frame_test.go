@@ -0,0 +1,62 @@
+package serial
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	frames := []Frame{
+		{Kind: Data, Seq: 1, Payload: []byte("hello")},
+		{Kind: Ack, Seq: 1, Payload: []byte{}},
+	}
+	buf.WriteString("noise\xa5")
+	for _, f := range frames {
+		if err := WriteFrame(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReader(&buf)
+	for i, expect := range frames {
+		f, err := r.ReadFrame()
+		if err != nil {
+			t.Fatalf("case %v: %v", i, err)
+		}
+		if f.Kind != expect.Kind || f.Seq != expect.Seq || !bytes.Equal(f.Payload, expect.Payload) {
+			t.Errorf("case %v: expected %v, got %v", i, expect, f)
+		}
+	}
+	if _, err := r.ReadFrame(); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestFrameErrors(t *testing.T) {
+	var good bytes.Buffer
+	WriteFrame(&good, Frame{Kind: Data, Seq: 7, Payload: []byte("hello")})
+	corrupt := append([]byte(nil), good.Bytes()...)
+	corrupt[len(corrupt)-5] ^= 0xff // a payload byte
+
+	tooLarge := []byte{0xA5, 0x5A, byte(Data), 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff}
+
+	cases := []struct {
+		given  []byte
+		expect error
+	}{
+		{given: corrupt, expect: ErrChecksum},
+		{given: tooLarge, expect: ErrTooLarge},
+		{given: good.Bytes()[:8], expect: io.ErrUnexpectedEOF},
+	}
+	for i, c := range cases {
+		if _, err := NewReader(bytes.NewReader(c.given)).ReadFrame(); err != c.expect {
+			t.Errorf("case %v: expected %v, got %v", i, c.expect, err)
+		}
+	}
+
+	if err := WriteFrame(&good, Frame{Payload: make([]byte, MaxPayload+1)}); err != ErrTooLarge {
+		t.Errorf("expected %v, got %v", ErrTooLarge, err)
+	}
+}
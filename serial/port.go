@@ -0,0 +1,131 @@
+// +build linux
+
+package serial
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Options hold the line settings of a serial port. Data bits are always 8.
+type Options struct {
+	Baud        int    // bits per second
+	Parity      string // "none", "even" or "odd"
+	StopBits    int    // 1 or 2
+	FlowControl string // "none", "rtscts" or "xonxoff"
+}
+
+var bauds = map[int]uint32{
+	1200:    unix.B1200,
+	2400:    unix.B2400,
+	4800:    unix.B4800,
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	2000000: unix.B2000000,
+}
+
+// Validate returns an error if o cannot be applied.
+func (o Options) Validate() error {
+	if _, ok := bauds[o.Baud]; !ok {
+		return fmt.Errorf("serial: unsupported baud rate %v", o.Baud)
+	}
+	switch o.Parity {
+	case "none", "even", "odd":
+	default:
+		return fmt.Errorf(`serial: parity must be "none", "even" or "odd", not %q`, o.Parity)
+	}
+	switch o.StopBits {
+	case 1, 2:
+	default:
+		return fmt.Errorf("serial: stop bits must be 1 or 2, not %v", o.StopBits)
+	}
+	switch o.FlowControl {
+	case "none", "rtscts", "xonxoff":
+	default:
+		return fmt.Errorf(`serial: flow control must be "none", "rtscts" or "xonxoff", not %q`, o.FlowControl)
+	}
+	return nil
+}
+
+// Open opens the serial device at path in raw mode with the line settings in
+// o.
+func Open(path string, o Options) (*os.File, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	// Without O_NONBLOCK, opening a port whose modem lines are down could
+	// block until they come up.
+	f, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	// f.Fd would put f into blocking mode, after which closing f would no
+	// longer interrupt a pending Read.
+	conn, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if cerr := conn.Control(func(fd uintptr) { err = configure(int(fd), o) }); cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("serial: configuring %v: %v", path, err)
+	}
+	return f, nil
+}
+
+// configure applies o to the terminal fd.
+func configure(fd int, o Options) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+
+	// raw mode, as by cfmakeraw(3)
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY | unix.INPCK
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS | unix.CBAUD
+	t.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL
+
+	baud := bauds[o.Baud]
+	t.Cflag |= baud
+	t.Ispeed = baud
+	t.Ospeed = baud
+
+	switch o.Parity {
+	case "even":
+		t.Cflag |= unix.PARENB
+		t.Iflag |= unix.INPCK
+	case "odd":
+		t.Cflag |= unix.PARENB | unix.PARODD
+		t.Iflag |= unix.INPCK
+	}
+	if o.StopBits == 2 {
+		t.Cflag |= unix.CSTOPB
+	}
+	switch o.FlowControl {
+	case "rtscts":
+		t.Cflag |= unix.CRTSCTS
+	case "xonxoff":
+		t.Iflag |= unix.IXON | unix.IXOFF
+	}
+
+	// Reads return as soon as a byte is available.
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
@@ -0,0 +1,128 @@
+// +build linux
+
+package serial
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY returns the master side of a new pseudoterminal and the path of its
+// slave side.
+func openPTY(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("no pseudoterminals:", err)
+	}
+	conn, err := master.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n uint32
+	conn.Control(func(fd uintptr) {
+		var unlock int32
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, unix.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+			err = errno
+			return
+		}
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, unix.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+			err = errno
+		}
+	})
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%v", n)
+}
+
+func TestOpen(t *testing.T) {
+	master, slave := openPTY(t)
+	defer master.Close()
+
+	cases := []struct {
+		opt Options
+		ok  bool
+	}{
+		{opt: Options{Baud: 9600, Parity: "even", StopBits: 2, FlowControl: "rtscts"}, ok: true},
+		{opt: Options{Baud: 1234, Parity: "none", StopBits: 1, FlowControl: "none"}, ok: false},
+		{opt: Options{Baud: 9600, Parity: "mark", StopBits: 1, FlowControl: "none"}, ok: false},
+		{opt: Options{Baud: 9600, Parity: "none", StopBits: 3, FlowControl: "none"}, ok: false},
+		{opt: Options{Baud: 9600, Parity: "none", StopBits: 1, FlowControl: "dtrdsr"}, ok: false},
+	}
+	for i, c := range cases {
+		f, err := Open(slave, c.opt)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c.ok, ok, err)
+		}
+		if err != nil {
+			continue
+		}
+		conn, _ := f.SyscallConn()
+		var tio *unix.Termios
+		conn.Control(func(fd uintptr) { tio, err = unix.IoctlGetTermios(int(fd), unix.TCGETS) })
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Pseudoterminals ignore parity, so that cannot be checked.
+		want := uint32(unix.B9600 | unix.CS8 | unix.CSTOPB | unix.CRTSCTS)
+		if tio.Cflag&want != want || tio.Lflag&unix.ICANON != 0 {
+			t.Errorf("case %v: termios not applied: cflag %#o, lflag %#o", i, tio.Cflag, tio.Lflag)
+		}
+	}
+}
+
+// receiver answers the data frames it reads from port, except for the first
+// drop of them.
+func receiver(port *os.File, drop int) {
+	r := NewReader(port)
+	for {
+		f, err := r.ReadFrame()
+		if err != nil {
+			return
+		}
+		if f.Kind != Data {
+			continue
+		}
+		if drop > 0 {
+			drop--
+			continue
+		}
+		WriteFrame(port, Frame{Kind: Ack, Seq: f.Seq})
+	}
+}
+
+func TestSender(t *testing.T) {
+	cases := []struct {
+		drop int
+		ok   bool
+	}{
+		{drop: 0, ok: true},
+		{drop: 2, ok: true},
+		{drop: 3, ok: false},
+	}
+	for i, c := range cases {
+		master, slave := openPTY(t)
+		go receiver(master, c.drop)
+		s := Sender{
+			Open: func() (io.ReadWriteCloser, error) {
+				return Open(slave, Options{Baud: 115200, Parity: "none", StopBits: 1, FlowControl: "none"})
+			},
+			AckTimeout: 100 * time.Millisecond,
+			Retries:    2,
+		}
+		err := s.Send([]byte("hello"))
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c.ok, ok, err)
+		}
+		s.Close()
+		master.Close()
+	}
+}
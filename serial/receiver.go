@@ -0,0 +1,41 @@
+package serial
+
+import (
+	"io"
+	"log"
+)
+
+// Receive reads Data frames from port and passes their payloads to handle,
+// acknowledging each frame for which handle returns nil. A retransmission of
+// the frame last acknowledged, sent because its acknowledgement was lost, is
+// acknowledged again without being handled. Receive returns when reading from
+// or writing to port fails.
+func Receive(port io.ReadWriter, handle func(payload []byte) error) error {
+	frames := NewReader(port)
+	var last uint32
+	acked := false
+	for {
+		f, err := frames.ReadFrame()
+		switch {
+		case err == ErrChecksum || err == ErrTooLarge:
+			log.Print(err)
+			continue
+		case err != nil:
+			return err
+		case f.Kind != Data:
+			continue
+		}
+		if !acked || f.Seq != last {
+			if err := handle(f.Payload); err != nil {
+				// Without an acknowledgement, the sender
+				// retransmits the frame.
+				log.Printf("serial: %v: %v", f, err)
+				continue
+			}
+		}
+		if err := WriteFrame(port, Frame{Kind: Ack, Seq: f.Seq}); err != nil {
+			return err
+		}
+		last, acked = f.Seq, true
+	}
+}
@@ -0,0 +1,114 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReceive(t *testing.T) {
+	cases := []struct {
+		send    []Frame
+		fail    int // number of payloads refused by the handler
+		handled []string
+		acks    []uint32
+	}{
+		{
+			send:    []Frame{{Kind: Data, Seq: 1, Payload: []byte("a")}, {Kind: Data, Seq: 2, Payload: []byte("b")}},
+			handled: []string{"a", "b"},
+			acks:    []uint32{1, 2},
+		},
+		{
+			// a retransmission after a lost acknowledgement
+			send:    []Frame{{Kind: Data, Seq: 1, Payload: []byte("a")}, {Kind: Data, Seq: 1, Payload: []byte("a")}},
+			handled: []string{"a"},
+			acks:    []uint32{1, 1},
+		},
+		{
+			send:    []Frame{{Kind: Data, Seq: 1, Payload: []byte("a")}, {Kind: Data, Seq: 1, Payload: []byte("a")}},
+			fail:    1,
+			handled: []string{"a"},
+			acks:    []uint32{1},
+		},
+		{
+			send:    []Frame{{Kind: Ack, Seq: 5}, {Kind: Data, Seq: 6, Payload: []byte("a")}},
+			handled: []string{"a"},
+			acks:    []uint32{6},
+		},
+	}
+	for i, c := range cases {
+		local, remote := net.Pipe()
+		var handled []string
+		fail := c.fail
+		done := make(chan error)
+		go func() {
+			done <- Receive(remote, func(payload []byte) error {
+				if fail > 0 {
+					fail--
+					return errors.New("refused")
+				}
+				handled = append(handled, string(payload))
+				return nil
+			})
+		}()
+
+		acks := make(chan uint32)
+		go func() {
+			defer close(acks)
+			r := NewReader(local)
+			for {
+				f, err := r.ReadFrame()
+				if err != nil {
+					return
+				}
+				acks <- f.Seq
+			}
+		}()
+		var got []uint32
+		for _, f := range c.send {
+			WriteFrame(local, f)
+			// Expect an acknowledgement unless Receive has nothing to
+			// answer.
+			select {
+			case seq := <-acks:
+				got = append(got, seq)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+		local.Close()
+		if err := <-done; err != io.EOF && err != io.ErrClosedPipe {
+			t.Errorf("case %v: expected EOF, got %v", i, err)
+		}
+		if !reflect.DeepEqual(handled, c.handled) {
+			t.Errorf("case %v: expected %v, got %v", i, c.handled, handled)
+		}
+		if !reflect.DeepEqual(got, c.acks) {
+			t.Errorf("case %v: expected acks %v, got %v", i, c.acks, got)
+		}
+	}
+}
+
+func TestSenderReceive(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	received := make(chan string, 2)
+	go Receive(remote, func(payload []byte) error {
+		received <- string(payload)
+		return nil
+	})
+	s := Sender{
+		Open:       func() (io.ReadWriteCloser, error) { return local, nil },
+		AckTimeout: time.Second,
+	}
+	for _, p := range []string{"hello", "world"} {
+		if err := s.Send([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; got != p {
+			t.Errorf("expected %v, got %v", p, got)
+		}
+	}
+}
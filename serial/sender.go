@@ -0,0 +1,120 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"log"
+	"time"
+)
+
+var errNoAck = errors.New("serial: no acknowledgement")
+
+// Sender sends payloads as Data frames over a port and waits for them to be
+// acknowledged. The port is opened on first use and kept open until an error
+// occurs on it, after which it is reopened on the next Send.
+type Sender struct {
+	Open       func() (io.ReadWriteCloser, error)
+	AckTimeout time.Duration // how long to wait for each acknowledgement
+	Retries    int           // retransmissions after the first attempt
+
+	port io.ReadWriteCloser
+	acks chan uint32   // sequence numbers of received Ack frames
+	dead chan struct{} // closes when reading from port fails
+	seq  uint32
+}
+
+// Send transmits payload and returns nil once the receiver has acknowledged
+// it.
+func (s *Sender) Send(payload []byte) error {
+	if s.port == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.seq == 0 {
+		// A receiver takes a frame with the sequence number it last
+		// acknowledged for a retransmission, so don't start where an
+		// earlier run of the sender may have stopped.
+		s.seq = uint32(time.Now().UnixNano())
+	}
+	s.seq++
+	f := Frame{Kind: Data, Seq: s.seq, Payload: payload}
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if err := WriteFrame(s.port, f); err != nil {
+			s.Close()
+			return err
+		}
+		if err := s.await(f.Seq); err != errNoAck {
+			return err
+		}
+		log.Printf("serial: no acknowledgement for %v", f)
+	}
+	return errNoAck
+}
+
+// await waits for the acknowledgement of seq.
+func (s *Sender) await(seq uint32) error {
+	timeout := time.NewTimer(s.AckTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case ack := <-s.acks:
+			if ack == seq {
+				return nil
+			}
+			// a late acknowledgement of an earlier frame
+		case <-s.dead:
+			s.Close()
+			return errors.New("serial: port closed")
+		case <-timeout.C:
+			return errNoAck
+		}
+	}
+}
+
+// open opens the port and starts reading acknowledgements from it.
+func (s *Sender) open() error {
+	port, err := s.Open()
+	if err != nil {
+		return err
+	}
+	s.port = port
+	s.acks = make(chan uint32, 16)
+	s.dead = make(chan struct{})
+	go readAcks(port, s.acks, s.dead)
+	return nil
+}
+
+// readAcks sends the sequence numbers of the Ack frames read from r to acks,
+// and closes dead when reading fails.
+func readAcks(r io.Reader, acks chan<- uint32, dead chan<- struct{}) {
+	defer close(dead)
+	frames := NewReader(r)
+	for {
+		f, err := frames.ReadFrame()
+		switch {
+		case err == ErrChecksum || err == ErrTooLarge:
+			log.Print(err)
+			continue
+		case err != nil:
+			return
+		case f.Kind != Ack:
+			continue
+		}
+		select {
+		case acks <- f.Seq:
+		default:
+			// Nobody is waiting for so many acknowledgements.
+		}
+	}
+}
+
+// Close closes the port, if it is open.
+func (s *Sender) Close() error {
+	if s.port == nil {
+		return nil
+	}
+	err := s.port.Close()
+	s.port = nil
+	return err
+}
@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+)
+
+// File appends each message as a line of JSON to the file at Path. When the
+// file would grow beyond MaxSize bytes, it is rotated: Path becomes Path.1,
+// Path.1 becomes Path.2, and so on, keeping at most Backups old files. If
+// MaxSize is not positive, the file is never rotated.
+type File struct {
+	Path    string
+	MaxSize int64
+	Backups int
+	Fs      Fs
+}
+
+// Serve appends the messages from in to f.Path.
+func (f File) Serve(in broker.MessageSource) {
+	var (
+		file afero.File
+		size int64
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+	for msg := range in.Output() {
+		b, err := encode(msg)
+		if err != nil {
+			log.Printf("file: could not serialize message: %v", err)
+			continue
+		}
+		b = append(b, '\n')
+
+		if file != nil && f.MaxSize > 0 && size > 0 && size+int64(len(b)) > f.MaxSize {
+			file.Close()
+			file = nil
+			if err := f.rotate(); err != nil {
+				log.Printf("file: could not rotate %v: %v", f.Path, err)
+			}
+		}
+		if file == nil {
+			if file, size, err = f.open(); err != nil {
+				log.Printf("file: %v", err)
+				continue
+			}
+		}
+		n, err := file.Write(b)
+		size += int64(n)
+		if err != nil {
+			log.Printf("file: could not write to %v: %v", f.Path, err)
+		}
+	}
+}
+
+// open opens f.Path for appending and returns its size.
+func (f File) open() (afero.File, int64, error) {
+	file, err := f.Fs.OpenFile(f.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Fs.Stat(f.Path)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// backup returns the path of the nth old file.
+func (f File) backup(n int) string {
+	return fmt.Sprintf("%v.%v", f.Path, n)
+}
+
+// rotate shifts the old files along, discarding the oldest, and makes the
+// current file the newest old file.
+func (f File) rotate() error {
+	if f.Backups < 1 {
+		return f.Fs.Remove(f.Path)
+	}
+	f.Fs.Remove(f.backup(f.Backups))
+	for n := f.Backups - 1; n >= 1; n-- {
+		if _, err := f.Fs.Stat(f.backup(n)); err == nil {
+			if err := f.Fs.Rename(f.backup(n), f.backup(n+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return f.Fs.Rename(f.Path, f.backup(1))
+}
@@ -0,0 +1,44 @@
+package sink
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+)
+
+func TestFile(t *testing.T) {
+	// Each encoded message is 39 bytes long, including the newline.
+	msg := broker.Message{Topic: broker.Event, Bytes: []byte(`{"n":1}`)}
+
+	cases := []struct {
+		maxSize  int64
+		backups  int
+		messages int
+		expect   map[string]int // number of lines in each file
+	}{
+		{maxSize: 0, backups: 2, messages: 5, expect: map[string]int{"out": 5, "out.1": 0}},
+		{maxSize: 80, backups: 2, messages: 5, expect: map[string]int{"out": 1, "out.1": 2, "out.2": 2}},
+		{maxSize: 80, backups: 1, messages: 7, expect: map[string]int{"out": 1, "out.1": 2, "out.2": 0}},
+		{maxSize: 80, backups: 0, messages: 3, expect: map[string]int{"out": 1, "out.1": 0}},
+	}
+
+	for i, c := range cases {
+		fs := afero.NewMemMapFs()
+		f := File{Path: "out", MaxSize: c.maxSize, Backups: c.backups, Fs: fs}
+		msgs := make([]broker.Message, c.messages)
+		for j := range msgs {
+			msgs[j] = msg
+		}
+		f.Serve(messages(msgs...))
+
+		for name, lines := range c.expect {
+			b, _ := afero.ReadFile(fs, name)
+			if n := strings.Count(string(b), "\n"); n != lines {
+				t.Errorf("case %v: expected %v lines in %v, got %v", i, lines, name, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+)
+
+// HTTP posts each message as JSON to URL.
+type HTTP struct {
+	URL    string
+	Client *http.Client // if nil, http.DefaultClient is used
+}
+
+// Serve posts the messages from in to h.URL.
+func (h HTTP) Serve(in broker.MessageSource) {
+	for msg := range in.Output() {
+		if err := h.post(msg); err != nil {
+			log.Printf("http: %v", err)
+		}
+	}
+}
+
+func (h HTTP) post(msg broker.Message) error {
+	b, err := encode(msg)
+	if err != nil {
+		return fmt.Errorf("could not serialize message: %v", err)
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(h.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status: %v from %v", resp.Status, h.URL)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+)
+
+func TestHTTP(t *testing.T) {
+	bodies := make(chan string, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(b)
+	}))
+	defer s.Close()
+
+	h := HTTP{URL: s.URL}
+	h.Serve(messages(broker.Message{Topic: broker.Event, Bytes: []byte(`{}`)}))
+
+	expect := `{"topic":"c/events/","payload":{}}`
+	if got := <-bodies; got != expect {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	if err := h.post(broker.Message{Topic: broker.Event}); err != nil {
+		t.Error(err)
+	}
+	<-bodies
+	h.URL = s.URL + "/%zz"
+	if err := h.post(broker.Message{Topic: broker.Event}); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"log"
+	"time"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+)
+
+// Serial sends each message, encoded as JSON, over an acknowledged serial
+// link. Messages are stored in Queue until the receiver acknowledges them, so
+// that those that cannot be delivered are retried, both during the run and by
+// later runs of the client. Messages are sent oldest first.
+type Serial struct {
+	Sender interface {
+		Send(payload []byte) error
+	}
+
+	// Persistor stores messages in the directory of Queue.
+	Persistor interface {
+		CreateMessage(*broker.Message) error
+	}
+	Queue broker.Queue
+
+	// RetryInterval is how often delivery of stored messages is retried
+	// after a failure.
+	RetryInterval time.Duration
+}
+
+// Serve sends the messages from in over s.Sender.
+func (s Serial) Serve(in broker.MessageSource) {
+	retry := time.NewTicker(s.RetryInterval)
+	defer retry.Stop()
+
+	// Messages stored by earlier runs go first.
+	backlog := !s.flush()
+	for {
+		select {
+		case msg, ok := <-in.Output():
+			if !ok {
+				if backlog {
+					s.flush()
+				}
+				return
+			}
+			// Take a copy of our own; msg may be stored for another
+			// sink.
+			m := broker.Message{
+				Error: msg.Error,
+				Topic: msg.Topic,
+				Bytes: msg.Bytes,
+			}
+			if err := s.Persistor.CreateMessage(&m); err != nil {
+				log.Printf("serial: could not store message: %v", err)
+			}
+			if backlog {
+				// Keep the order in which messages were
+				// produced.
+				continue
+			}
+			backlog = !s.send(m)
+		case <-retry.C:
+			if backlog {
+				backlog = !s.flush()
+			}
+		}
+	}
+}
+
+// send transmits m and removes it from the queue once it is acknowledged.
+// It reports whether m was handled.
+func (s Serial) send(m broker.Message) bool {
+	b, err := encode(m)
+	if err != nil {
+		// It will never be sent.
+		log.Printf("serial: could not serialize message: %v", err)
+		m.Remove()
+		return true
+	}
+	if err := s.Sender.Send(b); err != nil {
+		log.Printf("serial: %v", err)
+		return false
+	}
+	m.Remove()
+	return true
+}
+
+// flush sends the stored messages, stopping at the first that fails. It
+// reports whether all were handled.
+func (s Serial) flush() bool {
+	entries, err := s.Queue.List()
+	if err != nil {
+		log.Printf("serial: %v", err)
+		return false
+	}
+	for _, e := range entries {
+		if e.Topic == "" {
+			// unreadable; left for inspection with the queue command
+			continue
+		}
+		if !s.send(e.Message) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+// Package sink provides destinations for streams of broker messages.
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/schema"
+)
+
+// A Sink consumes a stream of broker messages. Serve must consume in until it
+// closes, and return once it has handled every message.
+type Sink interface {
+	Serve(in broker.MessageSource)
+}
+
+// Fs provides file system functions.
+type Fs interface {
+	OpenFile(string, int, os.FileMode) (afero.File, error)
+	Stat(string) (os.FileInfo, error)
+	Rename(string, string) error
+	Remove(string) error
+}
+
+// source is a broker.MessageSource backed by a channel.
+type source chan broker.Message
+
+func (s source) Output() <-chan broker.Message { return s }
+
+// Tee serves each of sinks with a copy of in, and returns when they have all
+// returned. Each message is handed to every sink before the next is read, so
+// a slow sink slows the others.
+func Tee(in broker.MessageSource, sinks ...Sink) {
+	if len(sinks) == 1 {
+		sinks[0].Serve(in)
+		return
+	}
+	var wg sync.WaitGroup
+	outs := make([]source, len(sinks))
+	for i, s := range sinks {
+		outs[i] = make(source)
+		wg.Add(1)
+		go func(s Sink, in source) {
+			defer wg.Done()
+			s.Serve(in)
+		}(s, outs[i])
+	}
+	for msg := range in.Output() {
+		for _, out := range outs {
+			out <- msg
+		}
+	}
+	for _, out := range outs {
+		close(out)
+	}
+	wg.Wait()
+}
+
+// encode returns msg in the JSON form written by the sinks in this package.
+// The payload is converted to JSON whatever its encoding.
+func encode(msg broker.Message) ([]byte, error) {
+	var payload interface{}
+	switch {
+	case len(msg.Bytes) == 0:
+	case json.Valid(msg.Bytes):
+		payload = json.RawMessage(msg.Bytes)
+	default:
+		v, err := schema.Decode(msg.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode payload: %v", err)
+		}
+		payload = v
+	}
+	return json.Marshal(struct {
+		Topic   string      `json:"topic"`
+		Payload interface{} `json:"payload"`
+		Error   string      `json:"error,omitempty"`
+	}{
+		Topic:   fmt.Sprintf("c/%v/", msg.Topic),
+		Payload: payload,
+		Error:   msg.Error,
+	})
+}
+
+// Decode parses a message in the form written by the sinks in this package,
+// such as one relayed by another client, and re-encodes its payload in enc.
+func Decode(b []byte, enc schema.Encoding) (broker.Message, error) {
+	var v struct {
+		Topic   string          `json:"topic"`
+		Payload json.RawMessage `json:"payload"`
+		Error   string          `json:"error"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return broker.Message{}, err
+	}
+	topic := broker.Topic(strings.TrimSuffix(strings.TrimPrefix(v.Topic, "c/"), "/"))
+	switch topic {
+	case broker.Profile, broker.Event, broker.Log:
+	default:
+		return broker.Message{}, fmt.Errorf("unknown topic %q", v.Topic)
+	}
+	msg := broker.Message{Topic: topic, Error: v.Error}
+	if len(v.Payload) == 0 || string(v.Payload) == "null" {
+		return msg, nil
+	}
+	payload, err := schema.FromJSON(v.Payload, enc)
+	if err != nil {
+		return broker.Message{}, fmt.Errorf("could not encode payload: %v", err)
+	}
+	msg.Bytes = payload
+	return msg, nil
+}
@@ -0,0 +1,167 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/vmihailenco/msgpack"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+	"github.com/aukletio/Auklet-Client-C/schema"
+)
+
+// messages returns a source that yields msgs, then closes.
+func messages(msgs ...broker.Message) source {
+	s := make(source, len(msgs))
+	for _, m := range msgs {
+		s <- m
+	}
+	close(s)
+	return s
+}
+
+func TestEncode(t *testing.T) {
+	packed, _ := msgpack.Marshal(map[string]int{"exitStatus": 3})
+	cases := []struct {
+		msg    broker.Message
+		expect string
+		ok     bool
+	}{
+		{
+			msg:    broker.Message{Topic: broker.Event, Bytes: []byte(`{"exitStatus":3}`)},
+			expect: `{"topic":"c/events/","payload":{"exitStatus":3}}`,
+			ok:     true,
+		},
+		{
+			msg:    broker.Message{Topic: broker.Event, Bytes: packed},
+			expect: `{"topic":"c/events/","payload":{"exitStatus":3}}`,
+			ok:     true,
+		},
+		{
+			msg:    broker.Message{Topic: broker.Log, Error: "oops"},
+			expect: `{"topic":"c/logs/","payload":null,"error":"oops"}`,
+			ok:     true,
+		},
+		{
+			msg: broker.Message{Topic: broker.Event, Bytes: []byte{0xc1}},
+			ok:  false,
+		},
+	}
+	for i, c := range cases {
+		b, err := encode(c.msg)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c.ok, ok, err)
+		}
+		if c.ok && string(b) != c.expect {
+			t.Errorf("case %v: expected %v, got %s", i, c.expect, b)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		in  string
+		enc schema.Encoding
+		ok  bool
+	}{
+		{in: `{"topic":"c/events/","payload":{"exitStatus":3,"load":0.5}}`, enc: schema.MsgPack, ok: true},
+		{in: `{"topic":"c/events/","payload":{"exitStatus":3,"load":0.5}}`, enc: schema.JSON, ok: true},
+		{in: `{"topic":"c/logs/","payload":null,"error":"oops"}`, enc: schema.MsgPack, ok: true},
+		{in: `{"topic":"c/other/","payload":null}`, enc: schema.MsgPack, ok: false},
+		{in: `{"topic":"c/logs/","payload":`, enc: schema.MsgPack, ok: false},
+	}
+	for i, c := range cases {
+		msg, err := Decode([]byte(c.in), c.enc)
+		if ok := err == nil; ok != c.ok {
+			t.Errorf("case %v: expected %v, got %v: %v", i, c.ok, ok, err)
+			continue
+		}
+		if !c.ok {
+			continue
+		}
+		if c.enc == schema.MsgPack && json.Valid(msg.Bytes) && len(msg.Bytes) > 0 {
+			t.Errorf("case %v: expected msgpack payload, got %s", i, msg.Bytes)
+		}
+		// Encoding the message again must give back the input.
+		b, err := encode(msg)
+		if err != nil {
+			t.Errorf("case %v: %v", i, err)
+			continue
+		}
+		if string(b) != c.in {
+			t.Errorf("case %v: expected %v, got %s", i, c.in, b)
+		}
+	}
+}
+
+func TestTee(t *testing.T) {
+	var a, b bytes.Buffer
+	Tee(messages(
+		broker.Message{Topic: broker.Event, Bytes: []byte(`{}`)},
+		broker.Message{Topic: broker.Profile, Bytes: []byte(`{}`)},
+	), Writer{&a}, Writer{&b})
+
+	for i, buf := range []*bytes.Buffer{&a, &b} {
+		if n := strings.Count(buf.String(), "\n"); n != 2 {
+			t.Errorf("case %v: expected 2 lines, got %v: %q", i, n, buf.String())
+		}
+	}
+}
+
+// mockSender records the payloads it is given, and fails to send them while
+// fail is true.
+type mockSender struct {
+	fail     bool
+	attempts int
+	sent     []string
+}
+
+func (m *mockSender) Send(payload []byte) error {
+	m.attempts++
+	if m.fail {
+		return errors.New("no acknowledgement")
+	}
+	m.sent = append(m.sent, string(payload))
+	return nil
+}
+
+func TestSerial(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	sender := &mockSender{fail: true}
+	s := Serial{
+		Sender:        sender,
+		Persistor:     broker.NewPersistor("queue", fs, nil),
+		Queue:         broker.Queue{Dir: "queue", Fs: fs},
+		RetryInterval: time.Hour,
+	}
+	msgs := []broker.Message{
+		{Topic: broker.Event, Bytes: []byte(`{"n":1}`)},
+		{Topic: broker.Event, Bytes: []byte(`{"n":2}`)},
+	}
+
+	// The first message fails, so the second is only stored. Delivery of
+	// the first is retried once before returning.
+	s.Serve(messages(msgs...))
+	if entries, _ := s.Queue.List(); len(entries) != 2 || sender.attempts != 2 {
+		t.Fatalf("expected 2 stored messages after 2 attempts, got %v after %v", len(entries), sender.attempts)
+	}
+
+	// A later run delivers the stored messages in order.
+	sender.fail = false
+	s.Serve(messages())
+	expect := []string{
+		`{"topic":"c/events/","payload":{"n":1}}`,
+		`{"topic":"c/events/","payload":{"n":2}}`,
+	}
+	if got := strings.Join(sender.sent, " "); got != strings.Join(expect, " ") {
+		t.Errorf("expected %v, got %v", expect, sender.sent)
+	}
+	if entries, _ := s.Queue.List(); len(entries) != 0 {
+		t.Errorf("expected empty queue, got %v messages", len(entries))
+	}
+}
@@ -0,0 +1,27 @@
+package sink
+
+import (
+	"io"
+	"log"
+
+	"github.com/aukletio/Auklet-Client-C/broker"
+)
+
+// Writer writes each message to W as a line of JSON.
+type Writer struct {
+	W io.Writer
+}
+
+// Serve writes the messages from in to w.W.
+func (w Writer) Serve(in broker.MessageSource) {
+	for msg := range in.Output() {
+		b, err := encode(msg)
+		if err != nil {
+			log.Printf("writer: could not serialize message: %v", err)
+			continue
+		}
+		if _, err := w.W.Write(append(b, '\n')); err != nil {
+			log.Printf("writer: %v", err)
+		}
+	}
+}